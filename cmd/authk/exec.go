@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/daemon"
+	"github.com/codozor/authk/internal/env"
+	"github.com/codozor/authk/internal/hooks"
+	"github.com/codozor/authk/internal/lock"
+	"github.com/codozor/authk/internal/oidc"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"golang.org/x/oauth2"
+)
+
+// Policies applied to the child when the token is refreshed.
+const (
+	OnRefreshRestart = "restart"
+	OnRefreshSignal  = "signal"
+	OnRefreshNone    = "none"
+)
+
+// stopGracePeriod is how long a child has to exit before it is killed.
+const stopGracePeriod = 10 * time.Second
+
+var (
+	execOnRefresh     string
+	execRefreshSignal string
+)
+
+var execCmd = &cobra.Command{
+	Use:   "exec [flags] -- command [args...]",
+	Short: "Run a command with the token in its environment",
+	Long: `Obtain a token, run the command with the token in its environment under
+the configured key(s), and keep the token fresh while the command runs.
+Signals are forwarded to the command and authk exits with its exit code.
+
+When the token is refreshed, --on-refresh decides what happens to the command:
+"restart" restarts it with the new token, "signal" sends it --refresh-signal,
+and "none" leaves it alone, for commands that read a target file listed in the
+config instead. The .env file is not written.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Keep the output of the command readable
+		closeLog, err := setupLogging(zerolog.WarnLevel)
+		if err != nil {
+			return err
+		}
+		defer closeLog()
+
+		var refreshSignal os.Signal
+		switch execOnRefresh {
+		case OnRefreshRestart, OnRefreshNone:
+		case OnRefreshSignal:
+			sig, err := hooks.ParseSignal(execRefreshSignal)
+			if err != nil {
+				return err
+			}
+			refreshSignal = sig
+		default:
+			return fmt.Errorf("unsupported --on-refresh %q, expected restart, signal or none", execOnRefresh)
+		}
+
+		// Try to find config file
+		if found, err := env.Find(cfgFile); err == nil {
+			cfgFile = found
+		}
+
+		// Load Config
+		cfg, err := config.Load(cfgFile)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		opts, err := serveOptions(cfg)
+		if err != nil {
+			return err
+		}
+		tokens := make(chan *oauth2.Token, 1)
+		opts.Notify = func(token *oauth2.Token) {
+			// Only the latest token matters
+			select {
+			case <-tokens:
+			default:
+			}
+			tokens <- token
+		}
+
+		// Only targets are written, commands can run side by side
+		var instanceLock *lock.Lock
+		if len(opts.Targets) > 0 {
+			instanceLock, err = lockTargets(cfgFile, opts.Targets)
+			if err != nil {
+				return err
+			}
+			defer releaseLock(instanceLock)
+		}
+
+		flushTraces, err := startTracing(cmd.Context(), cfg)
+		if err != nil {
+			return err
+		}
+		defer flushTraces()
+
+		// Initialize OIDC Client
+		client, err := oidc.NewClient(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to initialize OIDC client: %w", err)
+		}
+
+		ctx, cancel := context.WithCancel(cmd.Context())
+		defer cancel()
+
+		d := daemon.New(client, opts)
+		if instanceLock != nil {
+			go d.PublishStatus(ctx, instanceLock.StatePath())
+		}
+		runErr := make(chan error, 1)
+		runDone := make(chan struct{})
+		go func() {
+			runErr <- d.Run(ctx)
+			close(runDone)
+		}()
+		// Stop the maintenance on every return, and wait for its exit policy
+		// to apply before the lock is released
+		defer func() {
+			cancel()
+			<-runDone
+		}()
+
+		// Forward signals to the command rather than stopping authk
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, forwardedSignals...)
+		defer signal.Stop(signals)
+
+		var token *oauth2.Token
+		select {
+		case token = <-tokens:
+		case err := <-runErr:
+			return err
+		case sig := <-signals:
+			return fmt.Errorf("interrupted by %s before the command started", sig)
+		}
+
+		keys := tokenKeys(cfg)
+		child, err := startChild(args, keys, token)
+		if err != nil {
+			return err
+		}
+
+		for {
+			select {
+			case sig := <-signals:
+				if deliveredByTerminal(sig) {
+					log.Debug().Str("signal", sig.String()).Msg("Signal already delivered to the command by the terminal")
+					continue
+				}
+				log.Debug().Str("signal", sig.String()).Msg("Forwarding signal")
+				if err := child.cmd.Process.Signal(sig); err != nil {
+					log.Error().Err(err).Str("signal", sig.String()).Msg("Failed to forward signal")
+				}
+
+			case token = <-tokens:
+				switch execOnRefresh {
+				case OnRefreshRestart:
+					log.Info().Msg("Token refreshed, restarting command")
+					child.stop()
+					if child, err = startChild(args, keys, token); err != nil {
+						return err
+					}
+				case OnRefreshSignal:
+					log.Info().Str("signal", execRefreshSignal).Msg("Token refreshed, signalling command")
+					if err := child.cmd.Process.Signal(refreshSignal); err != nil {
+						log.Error().Err(err).Msg("Failed to signal command")
+					}
+				}
+
+			case <-child.done:
+				cancel()
+				if err := <-runErr; err != nil {
+					log.Error().Err(err).Msg("Token maintenance failed")
+				}
+				return exitStatus(child)
+
+			case err := <-runErr:
+				// Maintenance stopped on a permanent error, so the
+				// command would soon run with an expired token
+				log.Error().Err(err).Msg("Token maintenance failed, stopping command")
+				child.stop()
+				return err
+			}
+		}
+	},
+}
+
+// child is a running command.
+type child struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+}
+
+// startChild runs args with token set under every key.
+func startChild(args []string, keys []string, token *oauth2.Token) (*child, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for _, key := range keys {
+		cmd.Env = append(cmd.Env, key+"="+token.AccessToken)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", args[0], err)
+	}
+
+	c := &child{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		c.err = cmd.Wait()
+		close(c.done)
+	}()
+	return c, nil
+}
+
+// stop asks the child to exit and kills it after stopGracePeriod.
+func (c *child) stop() {
+	if err := terminate(c.cmd.Process); err != nil {
+		log.Debug().Err(err).Msg("Failed to terminate command")
+	}
+	select {
+	case <-c.done:
+	case <-time.After(stopGracePeriod):
+		log.Warn().Dur("grace_period", stopGracePeriod).Msg("Command did not exit, killing it")
+		_ = c.cmd.Process.Kill()
+		<-c.done
+	}
+}
+
+// exitStatus turns the way the child exited into the error returned by exec.
+func exitStatus(c *child) error {
+	var exitErr *exec.ExitError
+	if c.err != nil && !errors.As(c.err, &exitErr) {
+		return c.err
+	}
+	if code := exitCode(c.cmd.ProcessState); code != 0 {
+		return &exitCodeError{code: code}
+	}
+	return nil
+}
+
+// tokenKeys returns the environment variables the token is set under.
+func tokenKeys(cfg *config.Config) []string {
+	if len(cfg.Targets) == 0 {
+		return []string{cfg.TokenKey}
+	}
+	var keys []string
+	seen := map[string]bool{}
+	for _, target := range cfg.Targets {
+		if !seen[target.Key] {
+			seen[target.Key] = true
+			keys = append(keys, target.Key)
+		}
+	}
+	return keys
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+	execCmd.Flags().StringVar(&execOnRefresh, "on-refresh", OnRefreshRestart, "what to do with the command when the token is refreshed: restart, signal or none")
+	execCmd.Flags().StringVar(&execRefreshSignal, "refresh-signal", "HUP", "signal sent to the command with --on-refresh signal")
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/codozor/authk/internal/config"
+)
+
+func TestTokenKeys(t *testing.T) {
+	cfg := &config.Config{TokenKey: "TOKEN"}
+	if keys := tokenKeys(cfg); !slices.Equal(keys, []string{"TOKEN"}) {
+		t.Errorf("tokenKeys() without targets = %v, want [TOKEN]", keys)
+	}
+
+	cfg.Targets = []config.Target{
+		{File: ".env.1", Key: "API_TOKEN"},
+		{File: ".env.2", Key: "OTHER_TOKEN"},
+		{File: ".env.3", Key: "API_TOKEN"},
+	}
+	if keys := tokenKeys(cfg); !slices.Equal(keys, []string{"API_TOKEN", "OTHER_TOKEN"}) {
+		t.Errorf("tokenKeys() = %v, want [API_TOKEN OTHER_TOKEN]", keys)
+	}
+}
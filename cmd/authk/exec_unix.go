@@ -0,0 +1,56 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"slices"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// forwardedSignals are passed on to the command run by exec.
+var forwardedSignals = []os.Signal{
+	syscall.SIGINT,
+	syscall.SIGTERM,
+	syscall.SIGHUP,
+	syscall.SIGQUIT,
+	syscall.SIGUSR1,
+	syscall.SIGUSR2,
+	syscall.SIGWINCH,
+}
+
+// terminalSignals are sent by the terminal to its foreground process group,
+// which includes the command: it is started in the process group of authk.
+var terminalSignals = []os.Signal{
+	syscall.SIGINT,
+	syscall.SIGQUIT,
+	syscall.SIGWINCH,
+}
+
+// deliveredByTerminal reports whether the terminal already delivered sig to
+// the command. Forwarding it again would, for instance, deliver Ctrl+C
+// twice, which many dev servers take as a forced kill. The same signal sent
+// to authk alone, when it does not run in the foreground, is forwarded.
+func deliveredByTerminal(sig os.Signal) bool {
+	if !slices.Contains(terminalSignals, sig) {
+		return false
+	}
+	foreground, err := unix.IoctlGetInt(int(os.Stdin.Fd()), unix.TIOCGPGRP)
+	return err == nil && foreground == unix.Getpgrp()
+}
+
+// terminate asks a process to exit.
+func terminate(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
+
+// exitCode follows the shell convention of 128 plus the signal number for
+// processes killed by a signal.
+func exitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
@@ -0,0 +1,78 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestStartChild_ExitStatus(t *testing.T) {
+	token := &oauth2.Token{AccessToken: "secret"}
+
+	tests := []struct {
+		name   string
+		script string
+		code   int
+	}{
+		{"success", `test "$API_TOKEN" = secret`, 0},
+		{"failure", `exit 3`, 3},
+		{"signal", `kill -TERM $$`, 143},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := startChild([]string{"sh", "-c", tt.script}, []string{"API_TOKEN"}, token)
+			if err != nil {
+				t.Fatalf("startChild() error = %v", err)
+			}
+			<-c.done
+
+			err = exitStatus(c)
+			var exitErr *exitCodeError
+			switch {
+			case tt.code == 0 && err != nil:
+				t.Errorf("exitStatus() = %v, want nil", err)
+			case tt.code != 0 && (!errors.As(err, &exitErr) || exitErr.code != tt.code):
+				t.Errorf("exitStatus() = %v, want exit status %d", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestChild_Stop(t *testing.T) {
+	c, err := startChild([]string{"sleep", "60"}, nil, &oauth2.Token{})
+	if err != nil {
+		t.Fatalf("startChild() error = %v", err)
+	}
+	c.stop()
+
+	var exitErr *exitCodeError
+	if err := exitStatus(c); !errors.As(err, &exitErr) || exitErr.code != 143 {
+		t.Errorf("exitStatus() after stop = %v, want exit status 143", err)
+	}
+}
+
+func TestDeliveredByTerminal(t *testing.T) {
+	// Signals the terminal never sends are always forwarded
+	for _, sig := range []os.Signal{syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1} {
+		if deliveredByTerminal(sig) {
+			t.Errorf("deliveredByTerminal(%s) = true, want false", sig)
+		}
+	}
+
+	// Without a terminal in the foreground, Ctrl+C can only come from kill
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+	os.Stdin = devNull
+	if deliveredByTerminal(syscall.SIGINT) {
+		t.Error("deliveredByTerminal(SIGINT) without a terminal = true, want false")
+	}
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+)
+
+// forwardedSignals are passed on to the command run by exec. The console
+// already delivers Ctrl+C to the command, so it is only caught to keep authk
+// running until the command exits.
+var forwardedSignals = []os.Signal{
+	os.Interrupt,
+}
+
+// deliveredByTerminal reports whether the console already delivered sig to
+// the command, as it does for Ctrl+C.
+func deliveredByTerminal(sig os.Signal) bool {
+	return sig == os.Interrupt
+}
+
+// terminate asks a process to exit. Windows has no SIGTERM, so it is killed.
+func terminate(p *os.Process) error {
+	return p.Kill()
+}
+
+func exitCode(state *os.ProcessState) int {
+	return state.ExitCode()
+}
@@ -3,27 +3,56 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/codozor/authk/internal/agent"
 	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/env"
 	"github.com/codozor/authk/internal/oidc"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
+// agentMinTTL is the minimum remaining lifetime of a token from the agent.
+const agentMinTTL = 30 * time.Second
+
+var noAgent bool
+
 var getCmd = &cobra.Command{
 	Use:   "get",
 	Short: "Get a valid token",
-	Long:  `Get a valid token from the OIDC provider and print it to stdout.`,
+	Long: `Get a valid token and print it to stdout. The token comes from the agent
+started with authk --agent when one is running for the config, found through
+AUTHK_SOCK or its default socket, and from the OIDC provider otherwise.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Setup Logger
 		// Default to Error level to suppress Info logs (like "Using ... flow")
-		// unless debug is enabled.
-		logLevel := zerolog.ErrorLevel
-		if debug {
-			logLevel = zerolog.DebugLevel
+		closeLog, err := setupLogging(zerolog.ErrorLevel)
+		if err != nil {
+			return err
+		}
+		defer closeLog()
+
+		// Try to find config file
+		if found, err := env.Find(cfgFile); err == nil {
+			cfgFile = found
+		}
+
+		// Ask a running agent first, which avoids loading the config and
+		// authenticating again
+		if !noAgent {
+			sock := os.Getenv(agent.EnvSock)
+			if sock == "" {
+				sock = agent.SocketPath(cfgFile)
+			}
+			token, err := agent.Fetch(cmd.Context(), sock, cfgFile, agentMinTTL)
+			if err == nil {
+				log.Debug().Str("socket", sock).Msg("Token obtained from agent")
+				fmt.Println(token.AccessToken)
+				return nil
+			}
+			log.Debug().Err(err).Str("socket", sock).Msg("No usable agent, falling back to the IdP")
 		}
-		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr}).Level(logLevel)
 
 		// Load Config
 		cfg, err := config.Load(cfgFile)
@@ -38,7 +67,7 @@ var getCmd = &cobra.Command{
 		}
 
 		// Get Token
-		token, err := client.GetToken("", "")
+		token, err := client.GetToken(cmd.Context(), "", "")
 		if err != nil {
 			return fmt.Errorf("failed to get token: %w", err)
 		}
@@ -50,4 +79,5 @@ var getCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(getCmd)
+	getCmd.Flags().BoolVar(&noAgent, "no-agent", false, "always get the token from the OIDC provider")
 }
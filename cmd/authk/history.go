@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/codozor/authk/internal/audit"
+	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/display"
+	"github.com/codozor/authk/internal/env"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyFile    string
+	historySince   string
+	historyUntil   string
+	historyEvent   string
+	historySubject string
+	historyTarget  string
+	historyJSON    bool
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the tokens recorded in the audit log",
+	Long: `Show the tokens obtained by authk, from the audit log set by audit.file in the
+config, with the targets each was written to. Tokens themselves are never
+recorded, only their subject, ID (jti), expiry and scopes.
+
+--since and --until take a duration before now, such as 24h, a date such as
+2025-01-02, or an RFC 3339 time. A date given to --until includes that whole
+day.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		closeLog, err := setupLogging(zerolog.WarnLevel)
+		if err != nil {
+			return err
+		}
+		defer closeLog()
+
+		path := historyFile
+		if path == "" {
+			// Try to find config file
+			if found, err := env.Find(cfgFile); err == nil {
+				cfgFile = found
+			}
+			cfg, err := config.Load(cfgFile)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %w", err)
+			}
+			configPath, err := filepath.Abs(cfgFile)
+			if err != nil {
+				return fmt.Errorf("failed to resolve config path: %w", err)
+			}
+			if path = audit.Path(cfg, configPath); path == "" {
+				return fmt.Errorf("no audit log in %s, set audit.file or pass --file", configPath)
+			}
+		}
+
+		now := time.Now()
+		filter := audit.Filter{Event: historyEvent, Subject: historySubject, Target: historyTarget}
+		if filter.Since, err = parseTime(historySince, now, false); err != nil {
+			return fmt.Errorf("invalid --since: %w", err)
+		}
+		if filter.Until, err = parseTime(historyUntil, now, true); err != nil {
+			return fmt.Errorf("invalid --until: %w", err)
+		}
+		switch filter.Event {
+		case "", audit.EventIssued, audit.EventRefreshed, audit.EventRemoved, audit.EventWritten:
+		default:
+			return fmt.Errorf("unsupported --event %q, expected issued, refreshed, removed or written", filter.Event)
+		}
+
+		records, invalid, err := audit.Read(path, filter)
+		if err != nil {
+			return err
+		}
+		if invalid > 0 {
+			log.Warn().Int("lines", invalid).Str("file", path).Msg("Skipped unreadable audit log lines")
+		}
+
+		if historyJSON {
+			if records == nil {
+				records = []audit.Record{}
+			}
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(records); err != nil {
+				return fmt.Errorf("failed to encode output: %w", err)
+			}
+			return nil
+		}
+		printHistory(cmd.OutOrStdout(), records)
+		return nil
+	},
+}
+
+// parseTime parses a duration before now, a date or an RFC 3339 time. An
+// empty value is the zero time. A date stands for its start, or for its
+// last instant with endOfDay, so that an inclusive upper bound covers it.
+func parseTime(value string, now time.Time, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a duration, a date nor an RFC 3339 time", value)
+}
+
+func printHistory(w io.Writer, records []audit.Record) {
+	if len(records) == 0 {
+		fmt.Fprintln(w, "No tokens recorded")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tEVENT\tSUBJECT\tJTI\tEXPIRES\tSCOPES\tTARGETS")
+	for _, r := range records {
+		expiry := "-"
+		if !r.Expiry.IsZero() {
+			expiry = r.Expiry.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Time.Local().Format(time.DateTime),
+			r.Event,
+			display.OrDash(r.Subject),
+			display.OrDash(r.TokenID),
+			expiry,
+			display.OrDash(strings.Join(r.Scopes, " ")),
+			display.OrDash(strings.Join(r.Targets, ", ")),
+		)
+	}
+	_ = tw.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.Flags().StringVar(&historyFile, "file", "", "audit log to read instead of the one in the config")
+	historyCmd.Flags().StringVar(&historySince, "since", "", "only show tokens recorded since, such as 24h or 2025-01-02")
+	historyCmd.Flags().StringVar(&historyUntil, "until", "", "only show tokens recorded until, such as 1h or 2025-01-02")
+	historyCmd.Flags().StringVar(&historyEvent, "event", "", "only show issued, refreshed, removed or written events")
+	historyCmd.Flags().StringVar(&historySubject, "subject", "", "only show tokens for this subject (sub claim)")
+	historyCmd.Flags().StringVar(&historyTarget, "target", "", "only show tokens written to or removed from this file")
+	historyCmd.Flags().BoolVar(&historyJSON, "json", false, "output as JSON")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/audit"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value    string
+		endOfDay bool
+		want     time.Time
+	}{
+		{value: "", want: time.Time{}},
+		{value: "24h", want: now.Add(-24 * time.Hour)},
+		{value: "24h", endOfDay: true, want: now.Add(-24 * time.Hour)},
+		{value: "2025-01-01T08:00:00Z", want: time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)},
+		{value: "2025-01-01T08:00:00Z", endOfDay: true, want: time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)},
+		{value: "2025-01-01", want: time.Date(2025, 1, 1, 0, 0, 0, 0, time.Local)},
+		{value: "2025-01-01", endOfDay: true, want: time.Date(2025, 1, 1, 23, 59, 59, 999999999, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := parseTime(tt.value, now, tt.endOfDay)
+		if err != nil {
+			t.Errorf("parseTime(%q) error = %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseTime(%q, %v) = %v, want %v", tt.value, tt.endOfDay, got, tt.want)
+		}
+	}
+
+	// --until with a date keeps the records of that day
+	until, err := parseTime("2025-01-02", now, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := audit.Filter{Until: until}
+	if record := (audit.Record{Time: time.Date(2025, 1, 2, 18, 0, 0, 0, time.Local)}); !filter.Match(record) {
+		t.Errorf("--until 2025-01-02 excludes a record of that day")
+	}
+
+	if _, err := parseTime("yesterday", now, false); err == nil {
+		t.Error("expected an error for an unsupported time")
+	}
+}
+
+func TestPrintHistory(t *testing.T) {
+	var out bytes.Buffer
+	printHistory(&out, nil)
+	if !strings.Contains(out.String(), "No tokens recorded") {
+		t.Errorf("unexpected output without records:\n%s", out.String())
+	}
+
+	out.Reset()
+	printHistory(&out, []audit.Record{{
+		Time:    time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+		Event:   audit.EventIssued,
+		Subject: "service-account",
+		TokenID: "abc-123",
+		Scopes:  []string{"openid", "profile"},
+		Targets: []string{"/srv/a/.env", "/srv/b/.env"},
+	}})
+	for _, want := range []string{"issued", "service-account", "abc-123", "openid profile", "/srv/a/.env, /srv/b/.env"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+}
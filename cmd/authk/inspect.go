@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -10,17 +9,29 @@ import (
 
 	"github.com/codozor/authk/internal/config"
 	"github.com/codozor/authk/internal/env"
+	"github.com/codozor/authk/internal/jwtclaims"
+	"github.com/codozor/authk/internal/oidc"
 	"github.com/fatih/color"
+	"github.com/rs/zerolog"
 	"github.com/spf13/cobra"
 )
 
-var jsonOutput bool
+var (
+	jsonOutput  bool
+	measureSkew bool
+)
 
 var inspectCmd = &cobra.Command{
 	Use:   "inspect",
 	Short: "Inspect the current token",
 	Long:  `Read the token from the .env file and display its decoded content. Use the --json flag for machine-readable output.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		closeLog, err := setupLogging(zerolog.ErrorLevel)
+		if err != nil {
+			return err
+		}
+		defer closeLog()
+
 		// Try to find config file
 		if found, err := env.Find(cfgFile); err == nil {
 			cfgFile = found
@@ -78,12 +89,47 @@ var inspectCmd = &cobra.Command{
 		printJSON("Header", parts[0])
 		printJSON("Payload", parts[1])
 
+		// Inspecting is offline unless asked to contact the IdP: without
+		// --skew, expiry is shown on the local clock
+		var skew time.Duration
+		var skewErr error
+		if measureSkew {
+			skew, skewErr = oidc.MeasureClockSkew(cmd.Context(), cfg)
+		}
+		printExpiry(token, time.Now(), skew, skewErr)
+
 		return nil
 	},
 }
 
+// printExpiry shows when token expires by the IdP clock, which is the local
+// clock corrected by skew unless measuring it failed with skewErr.
+func printExpiry(token string, now time.Time, skew time.Duration, skewErr error) {
+	expiry := jwtclaims.Parse(token).Time("exp")
+	if expiry.IsZero() {
+		return
+	}
+
+	headerStyle := color.New(color.FgCyan, color.Bold)
+	headerStyle.Println("--- Expiry ---")
+
+	remaining := expiry.Sub(now.Add(skew)).Round(time.Second)
+	if remaining > 0 {
+		fmt.Printf("Expires %s, in %s\n", expiry.Format("2006-01-02 15:04:05 MST"), remaining)
+	} else {
+		fmt.Println(color.New(color.FgRed).Sprintf("Expired %s, %s ago", expiry.Format("2006-01-02 15:04:05 MST"), -remaining))
+	}
+
+	if skewErr != nil {
+		fmt.Println(color.New(color.Faint).Sprintf("Clock skew unknown: %v", skewErr))
+	} else if skew.Abs() >= time.Second {
+		fmt.Println(color.New(color.FgYellow).Sprintf("Local clock is %s the IdP", oidc.DescribeSkew(skew)))
+	}
+	fmt.Println()
+}
+
 func decodeSegment(segment string) (interface{}, error) {
-	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	decoded, err := jwtclaims.Segment(segment)
 	if err != nil {
 		return nil, err
 	}
@@ -174,4 +220,5 @@ func printJSON(title, segment string) {
 func init() {
 	rootCmd.AddCommand(inspectCmd)
 	inspectCmd.Flags().BoolVar(&jsonOutput, "json", false, "Output as valid JSON without colors")
+	inspectCmd.Flags().BoolVar(&measureSkew, "skew", false, "Measure the clock skew against the IdP and show the expiry by its clock")
 }
\ No newline at end of file
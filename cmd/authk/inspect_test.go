@@ -8,6 +8,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestDecodeSegment(t *testing.T) {
@@ -76,3 +77,31 @@ func TestPrintJSON(t *testing.T) {
 		t.Errorf("Output should contain the year 2024. Got:\n%s", output)
 	}
 }
+
+func TestPrintExpiry(t *testing.T) {
+	oldStdout := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	// The token expires in 10 minutes on the local clock, but the IdP clock
+	// is 5 minutes ahead
+	now := time.Unix(1733065200, 0)
+	jsonData, _ := json.Marshal(map[string]interface{}{"exp": now.Add(10 * time.Minute).Unix()})
+	printExpiry("eyJhbGciOiJub25lIn0."+base64.RawURLEncoding.EncodeToString(jsonData)+".sig", now, 5*time.Minute, nil)
+
+	w.Close()
+	os.Stdout = oldStdout
+
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		t.Fatalf("Failed to copy output: %v", err)
+	}
+	output := buf.String()
+
+	if !strings.Contains(output, "in 5m0s") {
+		t.Errorf("Output should contain the remaining time by the IdP clock. Got:\n%s", output)
+	}
+	if !strings.Contains(output, "5m0s behind") {
+		t.Errorf("Output should describe the clock skew. Got:\n%s", output)
+	}
+}
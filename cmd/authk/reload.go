@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/daemon"
+	"github.com/codozor/authk/internal/lock"
+	"github.com/codozor/authk/internal/oidc"
+	"github.com/rs/zerolog/log"
+)
+
+// configPollInterval is how often the config file is checked for changes.
+const configPollInterval = 2 * time.Second
+
+// watchConfig reloads the config when the file changes or on SIGHUP, and
+// hands the result to the daemon, with settings built by options. An OIDC
+// client is only rebuilt when the authentication settings changed. Invalid
+// configs are logged and ignored, as are configs adding a target file that
+// another instance maintains.
+func watchConfig(ctx context.Context, d *daemon.Daemon, instanceLock *lock.Lock, path string, current *config.Config, options func(*config.Config) (daemon.Options, error)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	changes := config.Watch(ctx, path, configPollInterval)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Info().Str("config", path).Msg("SIGHUP received, reloading config")
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+			log.Info().Str("config", path).Msg("Config file changed, reloading")
+		}
+
+		cfg, err := config.Load(path)
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid config, keeping current settings")
+			continue
+		}
+
+		opts, err := options(cfg)
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid config, keeping current settings")
+			continue
+		}
+
+		var client daemon.TokenClient
+		if !current.SameAuth(cfg) {
+			newClient, err := oidc.NewClient(cfg)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to initialize OIDC client, keeping current settings")
+				continue
+			}
+			client = newClient
+		}
+
+		if err := instanceLock.Retarget(targetFiles(opts.Targets)); err != nil {
+			log.Error().Err(err).Msg("Failed to lock reloaded targets, keeping current settings")
+			continue
+		}
+
+		// Only a config the daemon adopted is compared to the next one, so
+		// that a rejected client is built again
+		select {
+		case <-ctx.Done():
+			return
+		case applied := <-d.Reload(client, opts):
+			if !applied {
+				continue
+			}
+		}
+		current = cfg
+	}
+}
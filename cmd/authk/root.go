@@ -1,22 +1,49 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/codozor/authk/internal/agent"
+	"github.com/codozor/authk/internal/audit"
 	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/daemon"
+	"github.com/codozor/authk/internal/dashboard"
 	"github.com/codozor/authk/internal/env"
+	"github.com/codozor/authk/internal/hooks"
+	"github.com/codozor/authk/internal/lock"
+	"github.com/codozor/authk/internal/logging"
 	"github.com/codozor/authk/internal/oidc"
+	"github.com/codozor/authk/internal/retry"
+	"github.com/codozor/authk/internal/server"
+	"github.com/codozor/authk/internal/systemd"
+	"github.com/codozor/authk/internal/tracing"
+	"github.com/codozor/authk/internal/webhook"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
 
 var (
-	cfgFile string
-	envFile string
-	debug   bool
+	cfgFile       string
+	envFile       string
+	debug         bool
+	logFormat     string
+	logFile       string
+	logLevel      string
+	logMaxSize    int
+	logMaxBackups int
+	listenAddr    string
+	readyMinTTL   time.Duration
+	agentMode     bool
+	tuiMode       bool
 )
 
 var rootCmd = &cobra.Command{
@@ -27,14 +54,25 @@ updating a .env file with the valid token.`,
 	SilenceUsage:  true,
 	SilenceErrors: true,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		printBanner()
+		// The dashboard owns the terminal and lists the logs itself
+		logOpts := logOptions()
+		var events *dashboard.Events
+		if tuiMode {
+			if !logging.IsTerminal(os.Stdin) || !logging.IsTerminal(os.Stdout) {
+				return errors.New("--tui needs a terminal")
+			}
+			events = dashboard.NewEvents(dashboardEvents)
+			logOpts.Stderr, logOpts.Tee = io.Discard, events
+		}
+		closeLog, err := startLogging(logOpts, zerolog.InfoLevel)
+		if err != nil {
+			return err
+		}
+		defer closeLog()
 
-		// Setup Logger with Pretty Print
-		logLevel := zerolog.InfoLevel
-		if debug {
-			logLevel = zerolog.DebugLevel
+		if !tuiMode && logFormat == logging.FormatConsole && logFile == "" && logging.IsTerminal(os.Stdout) {
+			printBanner()
 		}
-		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr}).Level(logLevel)
 
 		// Try to find config file
 		if found, err := env.Find(cfgFile); err == nil {
@@ -52,86 +90,236 @@ updating a .env file with the valid token.`,
 			envFile = found
 		}
 
-		// Prepare targets
-		var targets []config.Target
+		// Prepare targets and daemon settings
+		opts, err := daemonOptions(cfg)
+		if err != nil {
+			return err
+		}
 		if len(cfg.Targets) > 0 {
-			targets = cfg.Targets
-			log.Info().Int("count", len(targets)).Msg("Configured with multiple targets")
+			log.Info().Int("count", len(opts.Targets)).Msg("Configured with multiple targets")
 		} else {
-			targets = []config.Target{{File: envFile, Key: cfg.TokenKey}}
 			log.Info().Str("env_file", envFile).Str("token_key", cfg.TokenKey).Msg("Configured with single target")
 		}
 
-		// Initialize OIDC Client
-		client, err := oidc.NewClient(cfg)
+		// Refuse to race another instance over the same targets
+		instanceLock, err := lockTargets(cfgFile, opts.Targets)
 		if err != nil {
-			return fmt.Errorf("failed to initialize OIDC client: %w", err)
+			return err
 		}
+		defer releaseLock(instanceLock)
 
-		// Initial Token Retrieval
-		token, err := client.GetToken("", "")
+		notifier, err := webhook.NewNotifier(cfg, cfgFile)
 		if err != nil {
-			return fmt.Errorf("failed to get initial token: %w", err)
+			return err
 		}
 
-		// Update all targets
-		for _, target := range targets {
-			mgr := env.NewManager(target.File, target.Key)
-			if err := mgr.Update(token.AccessToken); err != nil {
-				log.Error().Err(err).Str("file", target.File).Msg("Failed to update target")
-			} else {
-				log.Info().Str("file", target.File).Msg("Target updated")
-			}
+		flushTraces, err := startTracing(cmd.Context(), cfg)
+		if err != nil {
+			return err
 		}
+		defer flushTraces()
 
-		// Maintenance Loop
-		for {
-			// Calculate sleep time based on token expiry and a refresh buffer
-			refreshBuffer := 60 * time.Second // Refresh 60 seconds before expiry
-			sleepDuration := time.Until(token.Expiry) - refreshBuffer
-			if sleepDuration < 10*time.Second { // Ensure at least 10 seconds sleep
-				sleepDuration = 10 * time.Second
-			}
+		// Initialize OIDC Client
+		client, err := oidc.NewClient(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to initialize OIDC client: %w", err)
+		}
 
-			log.Info().Dur("sleep_duration", sleepDuration).Msg("Waiting for token refresh")
-			time.Sleep(sleepDuration)
+		// Stop gracefully on SIGINT/SIGTERM
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
 
-			// Attempt to refresh the token
-			newToken, err := client.RefreshToken(token)
+		d := daemon.New(client, opts)
+		go d.PublishStatus(ctx, instanceLock.StatePath())
+		go watchConfig(ctx, d, instanceLock, cfgFile, cfg, daemonOptions)
+
+		if listenAddr != "" {
+			ln, err := net.Listen("tcp", listenAddr)
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to refresh token, attempting full re-authentication")
-
-				// Try full re-authentication
-				newToken, err = client.GetToken("", "")
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to re-authenticate")
-					// Retry after short delay
-					time.Sleep(10 * time.Second)
-
-					// Force short sleep on next iteration to retry quickly
-					// By setting token.Expiry to now, time.Until will be negative,
-					// and sleepDuration will become 10s.
-					token.Expiry = time.Now()
-					continue
+				return fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+			}
+			log.Info().Str("address", ln.Addr().String()).Msg("Serving health endpoints and metrics")
+			go func() {
+				if err := server.Serve(ctx, ln, server.NewHandler(d, readyMinTTL)); err != nil {
+					log.Error().Err(err).Msg("HTTP server stopped")
 				}
+			}()
+		}
+
+		if agentMode {
+			path := agent.SocketPath(cfgFile)
+			ln, err := agent.Listen(path)
+			if err != nil {
+				return fmt.Errorf("failed to start agent: %w", err)
 			}
+			log.Info().Str("socket", path).Msg("Agent listening, set " + agent.EnvSock + " to this socket to share it")
+			go func() {
+				if err := server.Serve(ctx, ln, agent.Handler(d, cfgFile)); err != nil {
+					log.Error().Err(err).Msg("Agent stopped")
+				}
+			}()
+		}
 
-			// Update token
-			token = newToken
+		go systemd.Watch(ctx, d)
 
-			// Update all targets
-			for _, target := range targets {
-				mgr := env.NewManager(target.File, target.Key)
-				if err := mgr.Update(token.AccessToken); err != nil {
-					log.Error().Err(err).Str("file", target.File).Msg("Failed to update target")
-				} else {
-					log.Info().Str("file", target.File).Msg("Target updated")
+		go notifier.Watch(ctx, d)
+
+		if tuiMode {
+			// Quitting the dashboard stops authk, and the terminal is
+			// restored before anything else is printed
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				if err := dashboard.New(d, events, cfgFile).Run(ctx); err != nil {
+					log.Error().Err(err).Msg("Dashboard stopped")
 				}
-			}
+				stop()
+			}()
+			defer func() {
+				stop()
+				<-closed
+			}()
 		}
+
+		return runDaemon(ctx, d, notifier)
 	},
 }
 
+// dashboardEvents is how many log entries the dashboard keeps.
+const dashboardEvents = 200
+
+// notifyTimeout is how long authk waits on exit for webhook events to be
+// delivered.
+const notifyTimeout = 10 * time.Second
+
+// runDaemon runs d until ctx is cancelled, and reports to the webhooks when
+// it stops on an error.
+func runDaemon(ctx context.Context, d *daemon.Daemon, notifier *webhook.Notifier) error {
+	err := d.Run(ctx)
+	if err != nil {
+		notifier.Send(webhook.Event{Type: webhook.EventStopped, Error: err.Error()})
+	}
+	notifier.Close(notifyTimeout)
+	return err
+}
+
+// daemonOptions builds the daemon settings from the config.
+func daemonOptions(cfg *config.Config) (daemon.Options, error) {
+	retryPolicy, err := retry.NewPolicy(cfg.Retry)
+	if err != nil {
+		return daemon.Options{}, err
+	}
+	schedule, err := daemon.NewSchedule(cfg.Refresh)
+	if err != nil {
+		return daemon.Options{}, err
+	}
+	hookSet, err := hooks.NewSet(cfg)
+	if err != nil {
+		return daemon.Options{}, err
+	}
+	auditLog, err := audit.New(cfg, cfgFile)
+	if err != nil {
+		return daemon.Options{}, err
+	}
+
+	return daemon.Options{
+		Targets: resolveTargets(cfg),
+		OnExit:  cfg.OnExit,
+		Retry:   retryPolicy,
+		Refresh: schedule,
+		Hooks:   hookSet,
+		Audit:   auditLog,
+	}, nil
+}
+
+// lockTargets takes the single-instance lock for the config and targets.
+func lockTargets(configPath string, targets []config.Target) (*lock.Lock, error) {
+	l, err := lock.Acquire(configPath, targetFiles(targets))
+	if err != nil {
+		return nil, err
+	}
+	log.Debug().Str("lock_file", lock.Path(configPath)).Msg("Instance lock acquired")
+	return l, nil
+}
+
+func targetFiles(targets []config.Target) []string {
+	files := make([]string, len(targets))
+	for i, target := range targets {
+		files[i] = target.File
+	}
+	return files
+}
+
+func releaseLock(l *lock.Lock) {
+	if err := l.Release(); err != nil {
+		log.Error().Err(err).Msg("Failed to release instance lock")
+	}
+}
+
+// tracingTimeout is how long authk waits on exit for spans to be exported.
+const tracingTimeout = 5 * time.Second
+
+// startTracing exports traces as the config or the OTEL_* environment
+// variables say. The returned function flushes pending spans.
+func startTracing(ctx context.Context, cfg *config.Config) (func(), error) {
+	shutdown, err := tracing.Setup(ctx, cfg.Tracing, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up tracing: %w", err)
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tracingTimeout)
+		defer cancel()
+		if err := shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to export traces")
+		}
+	}, nil
+}
+
+// resolveTargets returns the configured targets, or the .env file and token
+// key when none are configured.
+func resolveTargets(cfg *config.Config) []config.Target {
+	if len(cfg.Targets) > 0 {
+		return cfg.Targets
+	}
+	return []config.Target{{File: envFile, Key: cfg.TokenKey}}
+}
+
+// setupLogging points the global logger at the log flags, at defaultLevel
+// unless --log-level or --debug is set. The returned function closes the log
+// file.
+func setupLogging(defaultLevel zerolog.Level) (func(), error) {
+	return startLogging(logOptions(), defaultLevel)
+}
+
+// logOptions returns the logging settings of the log flags.
+func logOptions() logging.Options {
+	opts := logging.Options{
+		Format:     logFormat,
+		Level:      logLevel,
+		File:       logFile,
+		MaxSize:    int64(logMaxSize) << 20,
+		MaxBackups: logMaxBackups,
+	}
+	if opts.Level == "" && debug {
+		opts.Level = zerolog.LevelDebugValue
+	}
+	return opts
+}
+
+// startLogging points the global logger at opts.
+func startLogging(opts logging.Options, defaultLevel zerolog.Level) (func(), error) {
+	logger, closeFile, err := logging.New(opts, defaultLevel)
+	if err != nil {
+		return nil, err
+	}
+	log.Logger = logger
+	return func() {
+		if err := closeFile(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to close log file: %v\n", err)
+		}
+	}, nil
+}
+
 func printBanner() {
 	banner := `
    __ _ _   _| |_| |__ | | __
@@ -142,10 +330,36 @@ func printBanner() {
 	fmt.Println(banner)
 }
 
+// exitCodeError makes authk exit with a specific exit code, such as the exit
+// code of the command run by authk exec. err is printed unless nil.
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		code := 1
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			code = exitErr.code
+			err = exitErr.err
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(code)
 	}
 }
 
@@ -153,4 +367,13 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "authk.cue", "config file (default is authk.cue)")
 	rootCmd.PersistentFlags().StringVar(&envFile, "env", ".env", "env file (default is .env)")
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "enable debug logging")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logging.FormatConsole, "log format: console or json")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "write logs to this file instead of stderr")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "log level: trace, debug, info, warn or error (default depends on the command)")
+	rootCmd.PersistentFlags().IntVar(&logMaxSize, "log-max-size", 10, "size in MB above which --log-file is rotated, 0 to never rotate")
+	rootCmd.PersistentFlags().IntVar(&logMaxBackups, "log-max-backups", 3, "number of rotated log files kept")
+	rootCmd.Flags().StringVar(&listenAddr, "listen", "", "address to serve /healthz, /readyz and /metrics on, such as 127.0.0.1:8080")
+	rootCmd.Flags().BoolVar(&agentMode, "agent", false, "answer authk get over a Unix socket only the current user can access")
+	rootCmd.Flags().BoolVar(&tuiMode, "tui", false, "show a live dashboard of the token and targets instead of the logs")
+	rootCmd.Flags().DurationVar(&readyMinTTL, "ready-min-ttl", 30*time.Second, "minimum remaining token lifetime for /readyz to succeed")
 }
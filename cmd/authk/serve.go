@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/daemon"
+	"github.com/codozor/authk/internal/env"
+	"github.com/codozor/authk/internal/oidc"
+	"github.com/codozor/authk/internal/server"
+	"github.com/codozor/authk/internal/systemd"
+	"github.com/codozor/authk/internal/webhook"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveAddr   string
+	serveCompat string
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the token like a cloud metadata server",
+	Long: `Maintain a token and serve it over HTTP on a local address, like a cloud
+metadata server, for tools that cannot read .env files.
+
+GET /token with the header "Metadata-Flavor: authk" returns the current token
+as JSON. --compat gce adds the GCE route
+/computeMetadata/v1/instance/service-accounts/default/token (header
+"Metadata-Flavor: Google"), and --compat azure the Azure IMDS route
+/metadata/identity/oauth2/token (header "Metadata: true").
+
+Targets listed in the config are still maintained, the .env file is not.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		closeLog, err := setupLogging(zerolog.InfoLevel)
+		if err != nil {
+			return err
+		}
+		defer closeLog()
+
+		switch serveCompat {
+		case server.CompatNone, server.CompatGCE, server.CompatAzure:
+		default:
+			return fmt.Errorf("unsupported --compat %q, expected gce or azure", serveCompat)
+		}
+
+		// Try to find config file
+		if found, err := env.Find(cfgFile); err == nil {
+			cfgFile = found
+		}
+
+		// Load Config
+		cfg, err := config.Load(cfgFile)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		opts, err := serveOptions(cfg)
+		if err != nil {
+			return err
+		}
+
+		// Refuse to race another instance over the same targets
+		instanceLock, err := lockTargets(cfgFile, opts.Targets)
+		if err != nil {
+			return err
+		}
+		defer releaseLock(instanceLock)
+
+		notifier, err := webhook.NewNotifier(cfg, cfgFile)
+		if err != nil {
+			return err
+		}
+
+		flushTraces, err := startTracing(cmd.Context(), cfg)
+		if err != nil {
+			return err
+		}
+		defer flushTraces()
+
+		// Initialize OIDC Client
+		client, err := oidc.NewClient(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to initialize OIDC client: %w", err)
+		}
+
+		ln, err := net.Listen("tcp", serveAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", serveAddr, err)
+		}
+		if tcpAddr, ok := ln.Addr().(*net.TCPAddr); ok && !tcpAddr.IP.IsLoopback() {
+			log.Warn().Str("address", ln.Addr().String()).Msg("Serving tokens on a non-loopback address, any host that can reach it can obtain them")
+		}
+
+		// Stop gracefully on SIGINT/SIGTERM
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		d := daemon.New(client, opts)
+		go d.PublishStatus(ctx, instanceLock.StatePath())
+		go watchConfig(ctx, d, instanceLock, cfgFile, cfg, serveOptions)
+
+		log.Info().Str("address", ln.Addr().String()).Str("compat", serveCompat).Msg("Serving token")
+		go func() {
+			if err := server.Serve(ctx, ln, server.NewMetadataHandler(d, serveCompat)); err != nil {
+				log.Error().Err(err).Msg("HTTP server stopped")
+			}
+		}()
+
+		go systemd.Watch(ctx, d)
+
+		go notifier.Watch(ctx, d)
+
+		return runDaemon(ctx, d, notifier)
+	},
+}
+
+// serveOptions builds the daemon settings for serve, which only maintains
+// the targets listed in the config.
+func serveOptions(cfg *config.Config) (daemon.Options, error) {
+	opts, err := daemonOptions(cfg)
+	if err != nil {
+		return daemon.Options{}, err
+	}
+	opts.Targets = cfg.Targets
+	return opts, nil
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveAddr, "listen", "127.0.0.1:8900", "address to serve the token on")
+	serveCmd.Flags().StringVar(&serveCompat, "compat", "", "add a metadata server compatible route: gce or azure")
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/env"
+	"github.com/codozor/authk/internal/systemd"
+	"github.com/spf13/cobra"
+)
+
+// serviceWatchdog is how long the daemon may go without pinging systemd
+// before it is restarted.
+const serviceWatchdog = time.Minute
+
+var (
+	serviceName     string
+	servicePrint    bool
+	serviceNoEnable bool
+)
+
+var installServiceCmd = &cobra.Command{
+	Use:   "install-service [flags] [-- daemon flags...]",
+	Short: "Install a systemd --user service maintaining the token",
+	Long: `Generate a systemd --user unit running the authk daemon for the config,
+then enable and start it. Flags after -- are passed to the daemon, such as
+--agent or --listen.
+
+The unit restarts the daemon when it fails and when it stops answering the
+systemd watchdog, and is only considered started once the first token is
+written.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if runtime.GOOS != "linux" && !servicePrint {
+			return errors.New("install-service requires systemd, use --print to only generate the unit")
+		}
+
+		// Try to find config file
+		if found, err := env.Find(cfgFile); err == nil {
+			cfgFile = found
+		}
+		configPath, err := filepath.Abs(cfgFile)
+		if err != nil {
+			return fmt.Errorf("failed to resolve config path: %w", err)
+		}
+		// Catch mistakes now rather than in the journal
+		if _, err := config.Load(configPath); err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		// Try to find .env file
+		if found, err := env.Find(envFile); err == nil {
+			envFile = found
+		}
+		envPath, err := filepath.Abs(envFile)
+		if err != nil {
+			return fmt.Errorf("failed to resolve env file path: %w", err)
+		}
+
+		executable, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to locate authk executable: %w", err)
+		}
+		if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+			executable = resolved
+		}
+
+		unit := systemd.Unit{
+			Description:      "authk token maintainer for " + configPath,
+			WorkingDirectory: filepath.Dir(configPath),
+			ExecStart:        append([]string{executable, "--config", configPath, "--env", envPath}, args...),
+			Watchdog:         serviceWatchdog,
+		}
+		if servicePrint {
+			fmt.Print(unit.String())
+			return nil
+		}
+
+		name := serviceName
+		if name == "" {
+			name = systemd.UnitName(configPath)
+		}
+		if filepath.Ext(name) != ".service" {
+			name += ".service"
+		}
+
+		dir, err := systemd.UnitDir()
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create unit directory: %w", err)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(unit.String()), 0644); err != nil {
+			return fmt.Errorf("failed to write unit: %w", err)
+		}
+		fmt.Printf("Wrote %s\n", path)
+
+		if err := systemctl("daemon-reload"); err != nil {
+			return err
+		}
+		if serviceNoEnable {
+			fmt.Printf("Start it with: systemctl --user enable --now %s\n", name)
+			return nil
+		}
+		if err := systemctl("enable", "--now", name); err != nil {
+			return err
+		}
+		fmt.Printf("Started %s, follow it with: journalctl --user -u %s -f\n", name, name)
+		return nil
+	},
+}
+
+// systemctl runs a systemctl --user command, showing its output.
+func systemctl(args ...string) error {
+	cmd := exec.Command("systemctl", append([]string{"--user"}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to run systemctl --user %v: %w", args, err)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(installServiceCmd)
+	installServiceCmd.Flags().StringVar(&serviceName, "name", "", "unit name (default authk-<config directory>.service)")
+	installServiceCmd.Flags().BoolVar(&servicePrint, "print", false, "print the unit instead of installing it")
+	installServiceCmd.Flags().BoolVar(&serviceNoEnable, "no-enable", false, "install the unit without enabling and starting it")
+}
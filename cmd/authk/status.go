@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/codozor/authk/internal/daemon"
+	"github.com/codozor/authk/internal/display"
+	"github.com/codozor/authk/internal/env"
+	"github.com/codozor/authk/internal/jwtclaims"
+	"github.com/codozor/authk/internal/lock"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var statusJSON bool
+
+// statusReport is the output of authk status.
+type statusReport struct {
+	Config    string           `json:"config"`
+	Running   bool             `json:"running"`
+	Instances []instanceReport `json:"instances"`
+}
+
+// instanceReport describes a running instance, with the state it publishes.
+type instanceReport struct {
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"startedAt"`
+	// State is missing while the instance starts up
+	State      *daemon.Status `json:"state,omitempty"`
+	StateError string         `json:"stateError,omitempty"`
+	Targets    []targetReport `json:"targets"`
+}
+
+// targetReport is the state of a target, with the token it currently holds.
+type targetReport struct {
+	daemon.TargetStatus
+	Subject      string    `json:"subject,omitempty"`
+	TokenExpiry  time.Time `json:"tokenExpiry,omitzero"`
+	TokenMissing bool      `json:"tokenMissing,omitempty"`
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show whether authk is running for the project and its state",
+	Long: `Show the authk instances maintaining tokens for the config, with each target,
+the subject and expiry of the token it holds, the outcome of the last refresh
+and when the next one is scheduled.
+
+Exits with status 1 when no instance is running for the config.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Try to find config file
+		if found, err := env.Find(cfgFile); err == nil {
+			cfgFile = found
+		}
+		configPath, err := filepath.Abs(cfgFile)
+		if err != nil {
+			return fmt.Errorf("failed to resolve config path: %w", err)
+		}
+
+		instances, err := lock.Find(configPath)
+		if err != nil {
+			return fmt.Errorf("failed to look for running instances: %w", err)
+		}
+
+		report := statusReport{Config: configPath, Running: len(instances) > 0, Instances: []instanceReport{}}
+		for _, instance := range instances {
+			report.Instances = append(report.Instances, describeInstance(instance))
+		}
+
+		if statusJSON {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				return fmt.Errorf("failed to encode output: %w", err)
+			}
+		} else {
+			printStatus(cmd.OutOrStdout(), report, time.Now())
+		}
+
+		if !report.Running {
+			return &exitCodeError{code: 1}
+		}
+		return nil
+	},
+}
+
+// describeInstance reads the state published by a running instance and the
+// tokens in its targets.
+func describeInstance(instance lock.Instance) instanceReport {
+	report := instanceReport{PID: instance.PID, StartedAt: instance.StartedAt, Targets: []targetReport{}}
+
+	state, err := daemon.ReadStatus(instance.StatePath())
+	if err != nil {
+		report.StateError = err.Error()
+		return report
+	}
+	report.State = &state
+
+	for _, target := range state.Targets {
+		t := targetReport{TargetStatus: target}
+		// Relative paths are relative to where the instance runs
+		file := target.File
+		if !filepath.IsAbs(file) && instance.Dir != "" {
+			file = filepath.Join(instance.Dir, file)
+		}
+		value, err := env.NewManager(file, target.Key).Get()
+		if err != nil || value == "" {
+			t.TokenMissing = true
+		} else {
+			claims := jwtclaims.Parse(value)
+			t.Subject, t.TokenExpiry = claims.String("sub"), claims.Time("exp")
+		}
+		report.Targets = append(report.Targets, t)
+	}
+	return report
+}
+
+func printStatus(w io.Writer, report statusReport, now time.Time) {
+	if !report.Running {
+		fmt.Fprintf(w, "%s for %s\n", color.New(color.FgRed).Sprint("No authk running"), report.Config)
+		return
+	}
+
+	headerStyle := color.New(color.FgCyan, color.Bold)
+	for i, instance := range report.Instances {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, headerStyle.Sprintf("authk running (pid %d, started %s)", instance.PID, display.Relative(instance.StartedAt, now)))
+		fmt.Fprintf(w, "Config:        %s\n", report.Config)
+
+		state := instance.State
+		if state == nil {
+			fmt.Fprintln(w, color.New(color.Faint).Sprintf("State unavailable: %s", instance.StateError))
+			continue
+		}
+
+		switch {
+		case state.LastError != "":
+			fmt.Fprintf(w, "Last refresh:  %s\n", color.New(color.FgRed).Sprintf("failed %s (attempt %d): %s", display.Relative(state.LastErrorAt, now), state.Attempt, state.LastError))
+		case state.HasToken():
+			fmt.Fprintf(w, "Last refresh:  %s\n", color.New(color.FgGreen).Sprintf("succeeded %s", display.Relative(state.IssuedAt, now)))
+		default:
+			fmt.Fprintf(w, "Last refresh:  none yet\n")
+		}
+		if !state.Expiry.IsZero() {
+			fmt.Fprintf(w, "Token expires: %s\n", display.Relative(state.Expiry, now))
+		}
+		if !state.NextRefresh.IsZero() {
+			fmt.Fprintf(w, "Next refresh:  %s\n", display.Relative(state.NextRefresh, now))
+		}
+
+		for _, target := range instance.Targets {
+			fmt.Fprintf(w, "\n%s (%s)\n", target.File, target.Key)
+			switch {
+			case target.Error != "":
+				fmt.Fprintf(w, "  %s\n", color.New(color.FgRed).Sprintf("Write failed: %s", target.Error))
+			case !target.UpdatedAt.IsZero():
+				fmt.Fprintf(w, "  Updated %s\n", display.Relative(target.UpdatedAt, now))
+			}
+			if target.TokenMissing {
+				fmt.Fprintf(w, "  %s\n", color.New(color.FgYellow).Sprint("No token in file"))
+				continue
+			}
+			if target.Subject != "" {
+				fmt.Fprintf(w, "  Subject: %s\n", target.Subject)
+			}
+			if !target.TokenExpiry.IsZero() {
+				if target.TokenExpiry.After(now) {
+					fmt.Fprintf(w, "  Token expires %s\n", display.Relative(target.TokenExpiry, now))
+				} else {
+					fmt.Fprintf(w, "  %s\n", color.New(color.FgRed).Sprintf("Token expired %s", display.Relative(target.TokenExpiry, now)))
+				}
+			}
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "output as JSON")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/daemon"
+)
+
+func TestPrintStatus(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var out bytes.Buffer
+	printStatus(&out, statusReport{Config: "/project/authk.cue"}, now)
+	if !strings.Contains(out.String(), "No authk running for /project/authk.cue") {
+		t.Errorf("unexpected output when not running:\n%s", out.String())
+	}
+
+	state := &daemon.Status{
+		IssuedAt:    now.Add(-10 * time.Minute),
+		Expiry:      now.Add(-time.Minute),
+		NextRefresh: now.Add(30 * time.Second),
+		LastError:   "connection refused",
+		LastErrorAt: now.Add(-30 * time.Second),
+		Attempt:     3,
+	}
+	report := statusReport{
+		Config:  "/project/authk.cue",
+		Running: true,
+		Instances: []instanceReport{{
+			PID:       42,
+			StartedAt: now.Add(-time.Hour),
+			State:     state,
+			Targets: []targetReport{{
+				TargetStatus: daemon.TargetStatus{File: ".env", Key: "TOKEN", UpdatedAt: now.Add(-10 * time.Minute)},
+				Subject:      "service-account",
+				TokenExpiry:  now.Add(-time.Minute),
+			}},
+		}},
+	}
+
+	out.Reset()
+	printStatus(&out, report, now)
+	for _, want := range []string{
+		"authk running (pid 42",
+		"failed", "(attempt 3): connection refused",
+		"Next refresh:", "(in 30s)",
+		".env (TOKEN)",
+		"Subject: service-account",
+		"Token expired", "(1m0s ago)",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
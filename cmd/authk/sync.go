@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/daemon"
+	"github.com/codozor/authk/internal/env"
+	"github.com/codozor/authk/internal/jwtclaims"
+	"github.com/codozor/authk/internal/lock"
+	"github.com/codozor/authk/internal/oidc"
+	"github.com/codozor/authk/internal/retry"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of authk sync, besides 0 when every target holds a valid token
+// and 1 for errors that may go away on their own, such as the IdP being down.
+const (
+	syncExitWriteFailed = 2
+	syncExitLocked      = 3
+	syncExitAuthFailed  = 4
+)
+
+var (
+	syncMinTTL time.Duration
+	syncForce  bool
+)
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Write a valid token to every target once and exit",
+	Long: `Obtain a token and write it to every target once, like authk does on start,
+then exit. The IdP is not contacted when every target already holds a token
+valid for at least --min-ttl. Hooks run for the targets written.
+
+Exit codes:
+  0  every target holds a valid token
+  1  error, such as the IdP being unreachable, that retrying may fix
+  2  some targets could not be written
+  3  another authk maintains the targets, and their token expires within --min-ttl
+  4  authentication was rejected, such as for invalid credentials`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		closeLog, err := setupLogging(zerolog.InfoLevel)
+		if err != nil {
+			return err
+		}
+		defer closeLog()
+
+		// Try to find config file
+		if found, err := env.Find(cfgFile); err == nil {
+			cfgFile = found
+		}
+
+		// Load Config
+		cfg, err := config.Load(cfgFile)
+		if err != nil {
+			return fmt.Errorf("failed to load config: %w", err)
+		}
+
+		// Try to find .env file
+		if found, err := env.Find(envFile); err == nil {
+			envFile = found
+		}
+
+		// Prepare targets and daemon settings
+		opts, err := daemonOptions(cfg)
+		if err != nil {
+			return err
+		}
+
+		fresh := !syncForce && targetsFresh(opts.Targets, time.Now(), syncMinTTL)
+
+		// Do not race a running instance over the same targets
+		instanceLock, err := lockTargets(cfgFile, opts.Targets)
+		var lockedErr *lock.LockedError
+		if errors.As(err, &lockedErr) {
+			if fresh {
+				log.Info().Str("lock_file", lockedErr.Path).Msg("Targets are maintained by another instance and still valid")
+				return nil
+			}
+			return &exitCodeError{code: syncExitLocked, err: err}
+		}
+		if err != nil {
+			return err
+		}
+		defer releaseLock(instanceLock)
+
+		if fresh {
+			log.Info().Dur("min_ttl", syncMinTTL).Msg("Tokens in every target are still valid, not contacting the IdP")
+			return nil
+		}
+
+		flushTraces, err := startTracing(cmd.Context(), cfg)
+		if err != nil {
+			return err
+		}
+		defer flushTraces()
+
+		// Initialize OIDC Client
+		client, err := oidc.NewClient(cfg)
+		if err != nil {
+			return fmt.Errorf("failed to initialize OIDC client: %w", err)
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		status, err := daemon.New(client, opts).Sync(ctx)
+		if err != nil {
+			if class := retry.Classify(err); !class.Retryable {
+				return &exitCodeError{code: syncExitAuthFailed, err: fmt.Errorf("%w (%s)", err, class.Reason)}
+			}
+			return err
+		}
+
+		failed := 0
+		for _, target := range status.Targets {
+			if target.Error != "" {
+				failed++
+			}
+		}
+		if failed > 0 {
+			return &exitCodeError{code: syncExitWriteFailed, err: fmt.Errorf("failed to write %d of %d targets", failed, len(status.Targets))}
+		}
+		return nil
+	},
+}
+
+// targetsFresh reports whether every target holds a token valid for at least
+// minTTL at now. Tokens whose expiry cannot be read, such as opaque tokens,
+// are not considered fresh.
+func targetsFresh(targets []config.Target, now time.Time, minTTL time.Duration) bool {
+	for _, target := range targets {
+		value, err := env.NewManager(target.File, target.Key).Get()
+		if err != nil || value == "" {
+			log.Debug().Str("file", target.File).Msg("No token in target")
+			return false
+		}
+		expiry := jwtclaims.Parse(value).Time("exp")
+		if expiry.IsZero() || expiry.Sub(now) < minTTL {
+			log.Debug().Str("file", target.File).Time("expiry", expiry).Msg("Token in target expires too soon")
+			return false
+		}
+	}
+	return true
+}
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+	syncCmd.Flags().DurationVar(&syncMinTTL, "min-ttl", 5*time.Minute, "minimum remaining lifetime for the tokens in the targets to be kept")
+	syncCmd.Flags().BoolVar(&syncForce, "force", false, "obtain a new token even if the targets hold valid ones")
+}
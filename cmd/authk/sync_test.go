@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+)
+
+func TestTargetsFresh(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+
+	write := func(name, value string) config.Target {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("TOKEN="+value+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return config.Target{File: path, Key: "TOKEN"}
+	}
+	jwt := func(expiry time.Time) string {
+		payload := base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, `{"exp":%d}`, expiry.Unix()))
+		return "eyJhbGciOiJub25lIn0." + payload + ".sig"
+	}
+
+	valid := write(".env.valid", jwt(now.Add(time.Hour)))
+	expiring := write(".env.expiring", jwt(now.Add(time.Minute)))
+	opaque := write(".env.opaque", "opaque-token")
+	missing := config.Target{File: filepath.Join(dir, ".env.missing"), Key: "TOKEN"}
+
+	tests := []struct {
+		name    string
+		targets []config.Target
+		fresh   bool
+	}{
+		{"Valid", []config.Target{valid}, true},
+		{"Expiring", []config.Target{valid, expiring}, false},
+		{"Opaque", []config.Target{opaque}, false},
+		{"Missing", []config.Target{valid, missing}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := targetsFresh(tt.targets, now, 5*time.Minute); got != tt.fresh {
+				t.Errorf("targetsFresh() = %v, want %v", got, tt.fresh)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/codozor/authk/internal/rundir"
+	"github.com/codozor/authk/internal/server"
+	"golang.org/x/oauth2"
+)
+
+// EnvSock is the environment variable pointing at the agent socket.
+const EnvSock = "AUTHK_SOCK"
+
+// configHeader carries the config path the agent maintains tokens for, so
+// that clients do not pick up a token meant for another project.
+const configHeader = "X-Authk-Config"
+
+// SocketPath returns the default socket path of the agent for the config at
+// configPath, in a directory only the current user can access.
+func SocketPath(configPath string) string {
+	// Socket paths are limited to about 100 bytes, so the config path is hashed
+	sum := sha256.Sum256([]byte(absPath(configPath)))
+	return filepath.Join(rundir.Dir(), "agent-"+hex.EncodeToString(sum[:6])+".sock")
+}
+
+// Listen creates the agent socket at path, readable only by the current
+// user. A stale socket left by a crashed agent is replaced; a live one is an
+// error.
+func Listen(path string) (net.Listener, error) {
+	if err := rundir.Ensure(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("an agent is already listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	return listenUnix(path)
+}
+
+// Handler answers token requests with the current token of source, for the
+// config at configPath.
+func Handler(source server.TokenSource, configPath string) http.Handler {
+	metadata := server.NewMetadataHandler(source, server.CompatNone)
+	abs := absPath(configPath)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(configHeader, abs)
+		metadata.ServeHTTP(w, r)
+	})
+}
+
+// Fetch asks the agent listening on path for a token for the config at
+// configPath, valid for at least minTTL. Sockets owned by another user are
+// refused.
+func Fetch(ctx context.Context, path, configPath string, minTTL time.Duration) (*oauth2.Token, error) {
+	if err := checkSocket(path); err != nil {
+		return nil, fmt.Errorf("failed to reach agent: %w", err)
+	}
+
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://agent/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "authk")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if got := resp.Header.Get(configHeader); got != absPath(configPath) {
+		return nil, fmt.Errorf("agent maintains tokens for %s, not %s", got, absPath(configPath))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("agent returned %s", resp.Status)
+	}
+
+	var body struct {
+		AccessToken string    `json:"access_token"`
+		TokenType   string    `json:"token_type"`
+		Expiry      time.Time `json:"expiry"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode agent response: %w", err)
+	}
+	if body.AccessToken == "" {
+		return nil, errors.New("agent returned no token")
+	}
+	if !body.Expiry.IsZero() && time.Until(body.Expiry) < minTTL {
+		return nil, fmt.Errorf("agent token expires in %s", time.Until(body.Expiry).Round(time.Second))
+	}
+
+	return &oauth2.Token{AccessToken: body.AccessToken, TokenType: body.TokenType, Expiry: body.Expiry}, nil
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,138 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/server"
+	"golang.org/x/oauth2"
+)
+
+type staticToken struct {
+	token *oauth2.Token
+}
+
+func (s staticToken) Token() *oauth2.Token {
+	return s.token
+}
+
+// shortTempDir keeps socket paths under the platform length limit.
+func shortTempDir(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "authk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func startAgent(t *testing.T, path, configPath string, token *oauth2.Token) {
+	t.Helper()
+	ln, err := Listen(path)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := server.Serve(ctx, ln, Handler(staticToken{token}, configPath)); err != nil {
+			t.Error(err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestAgent(t *testing.T) {
+	dir := shortTempDir(t)
+	path := filepath.Join(dir, "agent.sock")
+	configPath := filepath.Join(dir, "authk.cue")
+
+	startAgent(t, path, configPath, &oauth2.Token{AccessToken: "shared", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)})
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("socket permissions = %o, want 600", perm)
+		}
+	}
+
+	token, err := Fetch(context.Background(), path, configPath, 30*time.Second)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if token.AccessToken != "shared" {
+		t.Errorf("expected access token 'shared', got %s", token.AccessToken)
+	}
+
+	if _, err := Fetch(context.Background(), path, filepath.Join(dir, "other.cue"), 30*time.Second); err == nil {
+		t.Error("Fetch() expected error for another config, got nil")
+	}
+	if _, err := Fetch(context.Background(), path, configPath, 2*time.Hour); err == nil {
+		t.Error("Fetch() expected error for a token expiring too soon, got nil")
+	}
+
+	if _, err := Listen(path); err == nil || !strings.Contains(err.Error(), "already listening") {
+		t.Errorf("Listen() on a live socket error = %v, want already listening", err)
+	}
+}
+
+func TestListen_StaleSocket(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "agent.sock")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := Listen(path)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	ln.Close()
+}
+
+func TestFetch_NotASocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sockets are not checked on Windows")
+	}
+	path := filepath.Join(shortTempDir(t), "agent.sock")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Fetch(context.Background(), path, "authk.cue", 0); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("Fetch() error = %v, want not a socket", err)
+	}
+}
+
+func TestFetch_NoAgent(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "agent.sock")
+	if _, err := Fetch(context.Background(), path, "authk.cue", 0); err == nil {
+		t.Error("Fetch() expected error without agent, got nil")
+	}
+}
+
+func TestSocketPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("XDG_RUNTIME_DIR is not used on Windows")
+	}
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+
+	first := SocketPath("/home/me/a/authk.cue")
+	if !strings.HasPrefix(first, "/run/user/1000/authk/agent-") {
+		t.Errorf("unexpected socket path %s", first)
+	}
+	if first == SocketPath("/home/me/b/authk.cue") {
+		t.Error("expected different sockets for different configs")
+	}
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+
+package agent
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// listenUnix creates the socket and restricts it to mode 0600. The private
+// directory it is created in already keeps other users out until the chmod;
+// the umask is left alone since it is shared by the whole process.
+func listenUnix(path string) (net.Listener, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict socket: %w", err)
+	}
+	return listener, nil
+}
+
+// checkSocket refuses a socket that the current user does not own: anyone
+// can compute the config path an agent answers for, so a socket planted by
+// another user could hand out forged tokens.
+func checkSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s is not a socket", path)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by uid %d, not by the current user", path, stat.Uid)
+	}
+	return nil
+}
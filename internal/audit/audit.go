@@ -0,0 +1,164 @@
+// Package audit records every token authk obtains, and the targets it was
+// written to, in an append-only file of JSON lines. Tokens themselves are
+// never recorded, only claims identifying them.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/jwtclaims"
+	"golang.org/x/oauth2"
+)
+
+// Events.
+const (
+	// EventIssued is a token obtained by authenticating
+	EventIssued = "issued"
+	// EventRefreshed is a token obtained with a refresh token
+	EventRefreshed = "refreshed"
+	// EventRemoved is a token taken out of targets by the exit policy
+	EventRemoved = "removed"
+	// EventWritten is a token already recorded, written to targets added
+	// by a config reload
+	EventWritten = "written"
+)
+
+// Record is a line of the audit log.
+type Record struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Grant  string    `json:"grant,omitempty"`
+	Issuer string    `json:"issuer"`
+	// Subject, TokenID and Scopes come from the token response and the
+	// claims of the access token, when it is a JWT
+	Subject string    `json:"sub,omitempty"`
+	TokenID string    `json:"jti,omitempty"`
+	Expiry  time.Time `json:"exp,omitzero"`
+	Scopes  []string  `json:"scopes,omitempty"`
+	// Targets are the files the token was written to, or removed from
+	Targets []string `json:"targets"`
+	Host    string   `json:"host"`
+	Config  string   `json:"config"`
+}
+
+// Log appends records to the audit file. A nil *Log records nothing.
+type Log struct {
+	path   string
+	issuer string
+	grant  string
+	host   string
+	config string
+
+	mu sync.Mutex
+}
+
+// New returns the audit log configured in cfg, loaded from configPath, or nil
+// when there is none.
+func New(cfg *config.Config, configPath string) (*Log, error) {
+	if cfg.Audit == nil {
+		return nil, nil
+	}
+	if cfg.Audit.File == "" {
+		return nil, fmt.Errorf("audit.file is required")
+	}
+	configPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve config path: %w", err)
+	}
+	host, _ := os.Hostname()
+	return &Log{
+		path:   Path(cfg, configPath),
+		issuer: cfg.OIDC.IssuerURL,
+		grant:  cfg.GrantType(),
+		host:   host,
+		config: configPath,
+	}, nil
+}
+
+// Path returns the audit file configured in cfg, loaded from configPath, or
+// "" when there is none.
+func Path(cfg *config.Config, configPath string) string {
+	if cfg.Audit == nil || cfg.Audit.File == "" {
+		return ""
+	}
+	if filepath.IsAbs(cfg.Audit.File) {
+		return cfg.Audit.File
+	}
+	return filepath.Join(filepath.Dir(configPath), cfg.Audit.File)
+}
+
+// Record appends a record of event for token and the target files. Refreshed
+// tokens are recorded with the refresh_token grant.
+func (l *Log) Record(event string, token *oauth2.Token, targets []string) error {
+	if l == nil {
+		return nil
+	}
+
+	grant := l.grant
+	switch event {
+	case EventRefreshed:
+		grant = "refresh_token"
+	case EventRemoved, EventWritten:
+		grant = ""
+	}
+	// Relative targets mean nothing once read from elsewhere
+	files := make([]string, len(targets))
+	for i, target := range targets {
+		files[i] = absolute(target)
+	}
+	r := Record{
+		Time:    time.Now().UTC(),
+		Event:   event,
+		Grant:   grant,
+		Issuer:  l.issuer,
+		Expiry:  token.Expiry,
+		Targets: files,
+		Host:    l.host,
+		Config:  l.config,
+	}
+	r.Subject, r.TokenID, r.Scopes = describe(token)
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	// Each record is a single append, so that several instances can share
+	// the file
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// describe returns the subject, token ID and scopes of token, from the token
+// response and the claims of the access token when it is a JWT.
+func describe(token *oauth2.Token) (subject, tokenID string, scopes []string) {
+	claims := jwtclaims.Parse(token.AccessToken)
+	return claims.String("sub"), claims.String("jti"), jwtclaims.Scopes(token, claims)
+}
+
+func absolute(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,112 @@
+package audit
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"golang.org/x/oauth2"
+)
+
+func testJWT(claims string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	return header + "." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+}
+
+func TestLog_Record(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		OIDC:  config.OIDCConfig{IssuerURL: "https://idp.example.com"},
+		User:  config.UserConfig{Username: "alice", Password: "secret"},
+		Audit: &config.AuditConfig{File: filepath.Join("logs", "audit.log")},
+	}
+	l, err := New(cfg, filepath.Join(dir, "authk.cue"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	expiry := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	token := &oauth2.Token{
+		AccessToken:  testJWT(`{"sub":"alice","jti":"abc-123","scope":"openid profile"}`),
+		RefreshToken: "refresh-secret",
+		Expiry:       expiry,
+	}
+	if err := l.Record(EventIssued, token, []string{"/srv/app/.env"}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if err := l.Record(EventRefreshed, token.WithExtra(map[string]interface{}{"scope": "openid"}), nil); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	path := filepath.Join(dir, "logs", "audit.log")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{token.AccessToken, "refresh-secret", "secret\""} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("audit log contains %q:\n%s", secret, data)
+		}
+	}
+
+	records, invalid, err := Read(path, Filter{})
+	if err != nil || invalid != 0 {
+		t.Fatalf("Read() = %d invalid, %v", invalid, err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	issued, refreshed := records[0], records[1]
+	if issued.Grant != "password" || issued.Subject != "alice" || issued.TokenID != "abc-123" || !issued.Expiry.Equal(expiry) {
+		t.Errorf("unexpected issued record: %+v", issued)
+	}
+	if !reflect.DeepEqual(issued.Scopes, []string{"openid", "profile"}) || !reflect.DeepEqual(issued.Targets, []string{"/srv/app/.env"}) {
+		t.Errorf("unexpected issued record: %+v", issued)
+	}
+	// Scopes granted in the response win over the claim
+	if refreshed.Grant != "refresh_token" || !reflect.DeepEqual(refreshed.Scopes, []string{"openid"}) {
+		t.Errorf("unexpected refreshed record: %+v", refreshed)
+	}
+	if refreshed.Config != filepath.Join(dir, "authk.cue") || refreshed.Issuer != "https://idp.example.com" {
+		t.Errorf("unexpected refreshed record: %+v", refreshed)
+	}
+}
+
+func TestLog_RecordOpaqueToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(&config.Config{Audit: &config.AuditConfig{File: path}}, "authk.cue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Record(EventIssued, &oauth2.Token{AccessToken: "opaque"}, []string{".env"}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	records, _, err := Read(path, Filter{})
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Read() = %v, %v", records, err)
+	}
+	r := records[0]
+	if r.Subject != "" || r.TokenID != "" || !r.Expiry.IsZero() || r.Grant != "client_credentials" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+	// Relative targets are recorded as absolute paths
+	if len(r.Targets) != 1 || !filepath.IsAbs(r.Targets[0]) {
+		t.Errorf("expected an absolute target, got %v", r.Targets)
+	}
+}
+
+func TestLog_Nil(t *testing.T) {
+	l, err := New(&config.Config{}, "authk.cue")
+	if err != nil || l != nil {
+		t.Fatalf("New() = %v, %v, want nil without audit", l, err)
+	}
+	if err := l.Record(EventIssued, &oauth2.Token{}, nil); err != nil {
+		t.Errorf("Record() on nil log error = %v", err)
+	}
+}
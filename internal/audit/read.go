@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// Filter selects records. Zero fields select everything.
+type Filter struct {
+	Since   time.Time
+	Until   time.Time
+	Event   string
+	Subject string
+	// Target is a file the token was written to or removed from
+	Target string
+}
+
+// Match reports whether r is selected by f.
+func (f Filter) Match(r Record) bool {
+	switch {
+	case !f.Since.IsZero() && r.Time.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && r.Time.After(f.Until):
+		return false
+	case f.Event != "" && r.Event != f.Event:
+		return false
+	case f.Subject != "" && r.Subject != f.Subject:
+		return false
+	case f.Target != "" && !slices.Contains(r.Targets, absolute(f.Target)):
+		return false
+	}
+	return true
+}
+
+// Read returns the records of the audit file at path selected by f, oldest
+// first, and how many lines could not be decoded.
+func Read(path string, f Filter) ([]Record, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var records []Record
+	invalid := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(line, &r); err != nil {
+			invalid++
+			continue
+		}
+		if f.Match(r) {
+			records = append(records, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return records, invalid, nil
+}
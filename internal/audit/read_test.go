@@ -0,0 +1,58 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRead_Filter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	lines := `{"time":"2025-01-01T10:00:00Z","event":"issued","sub":"alice","targets":["/srv/a/.env"]}
+not json
+{"time":"2025-01-02T10:00:00Z","event":"refreshed","sub":"alice","targets":["/srv/a/.env","/srv/b/.env"]}
+
+{"time":"2025-01-03T10:00:00Z","event":"issued","sub":"bob","targets":["/srv/b/.env"]}
+`
+	if err := os.WriteFile(path, []byte(lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{name: "all", want: []string{"alice issued", "alice refreshed", "bob issued"}},
+		{name: "since", filter: Filter{Since: day(2)}, want: []string{"alice refreshed", "bob issued"}},
+		{name: "until", filter: Filter{Until: day(2)}, want: []string{"alice issued"}},
+		{name: "event", filter: Filter{Event: EventIssued}, want: []string{"alice issued", "bob issued"}},
+		{name: "subject", filter: Filter{Subject: "bob"}, want: []string{"bob issued"}},
+		{name: "target", filter: Filter{Target: "/srv/b/.env"}, want: []string{"alice refreshed", "bob issued"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, invalid, err := Read(path, tt.filter)
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if invalid != 1 {
+				t.Errorf("invalid = %d, want 1", invalid)
+			}
+			var got []string
+			for _, r := range records {
+				got = append(got, r.Subject+" "+r.Event)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"reflect"
 	"strings"
 
 	"cuelang.org/go/cue/cuecontext"
@@ -18,23 +19,151 @@ import (
 var schemaContent []byte
 
 type Config struct {
-	OIDC     OIDCConfig `json:"oidc"`
-	User     UserConfig `json:"user"`
-	TokenKey string     `json:"tokenKey"`
-	Targets  []Target   `json:"targets,omitempty"`
+	OIDC     OIDCConfig     `json:"oidc"`
+	User     UserConfig     `json:"user"`
+	SAML     *SAMLConfig    `json:"saml,omitempty"`
+	TokenKey string         `json:"tokenKey"`
+	Targets  []Target       `json:"targets,omitempty"`
+	OnExit   string         `json:"onExit"`
+	Retry    RetryConfig    `json:"retry"`
+	Refresh  RefreshConfig  `json:"refresh"`
+	Clock    ClockConfig    `json:"clock"`
+	OnUpdate []Hook         `json:"onUpdate,omitempty"`
+	Alerts   AlertsConfig   `json:"alerts"`
+	Tracing  *TracingConfig `json:"tracing,omitempty"`
+	Audit    *AuditConfig   `json:"audit,omitempty"`
 }
 
 type Target struct {
+	File     string `json:"file"`
+	Key      string `json:"key"`
+	OnUpdate []Hook `json:"onUpdate,omitempty"`
+}
+
+// Same reports whether both targets designate the same key in the same file.
+func (t Target) Same(other Target) bool {
+	return t.File == other.File && t.Key == other.Key
+}
+
+// Hook is an action run after the token is written. Exactly one of Command,
+// Signal or Touch is set.
+type Hook struct {
+	// Command is run with the token passed as Token says: "env" sets
+	// AUTHK_TOKEN, "stdin" writes it to standard input, "none" omits it
+	Command []string `json:"command,omitempty"`
+	Token   string   `json:"token,omitempty"`
+	// Signal, such as "HUP", is sent to PID or to the process in PIDFile
+	Signal  string `json:"signal,omitempty"`
+	PID     int    `json:"pid,omitempty"`
+	PIDFile string `json:"pidFile,omitempty"`
+	// Touch updates the modification time of a file, creating it if needed
+	Touch string `json:"touch,omitempty"`
+	// Timeout is a Go duration such as "30s"
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// RetryConfig is the backoff applied when re-authentication fails. Delays
+// are Go durations such as "10s".
+type RetryConfig struct {
+	BaseDelay  string  `json:"baseDelay"`
+	MaxDelay   string  `json:"maxDelay"`
+	Multiplier float64 `json:"multiplier"`
+	Jitter     float64 `json:"jitter"`
+}
+
+// RefreshConfig decides when tokens are refreshed. Durations are Go
+// durations such as "60s".
+type RefreshConfig struct {
+	// Buffer is how long before expiry the token is refreshed
+	Buffer string `json:"buffer"`
+	// LifetimePercent refreshes after this share of the token lifetime
+	// instead of Buffer, when set
+	LifetimePercent float64 `json:"lifetimePercent,omitempty"`
+	// MinInterval is the shortest time between two refreshes
+	MinInterval string `json:"minInterval"`
+	// UnknownLifetime is the refresh interval for tokens without any expiry
+	UnknownLifetime string `json:"unknownLifetime"`
+}
+
+// ClockConfig controls clock skew detection against the IdP.
+type ClockConfig struct {
+	// WarnSkewAbove is the skew, as a Go duration, above which authk warns
+	WarnSkewAbove string `json:"warnSkewAbove"`
+}
+
+// AlertsConfig sends notifications about failures and recovery to webhooks.
+type AlertsConfig struct {
+	// ExpiringWithin is how long before expiry a token that could not be
+	// renewed is reported, as a Go duration
+	ExpiringWithin string    `json:"expiringWithin"`
+	Webhooks       []Webhook `json:"webhooks,omitempty"`
+}
+
+// Webhook is a URL receiving notifications.
+type Webhook struct {
+	URL string `json:"url"`
+	// Format shapes the body: "json", "slack" or "teams"
+	Format string `json:"format,omitempty"`
+	// Template is a Go text/template rendering the body from the event,
+	// replacing Format
+	Template string `json:"template,omitempty"`
+	// Events limits the events sent, all by default
+	Events  []string          `json:"events,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Timeout is a Go duration such as "10s"
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// TracingConfig exports OpenTelemetry traces. The standard OTEL_*
+// environment variables apply to settings left out.
+type TracingConfig struct {
+	// Exporter is "otlp", "stdout" or "none"
+	Exporter string `json:"exporter,omitempty"`
+	// Endpoint is the OTLP endpoint, as host:port or a URL
+	Endpoint string `json:"endpoint,omitempty"`
+	// Protocol is "http/protobuf" or "grpc"
+	Protocol string `json:"protocol,omitempty"`
+	Insecure bool   `json:"insecure,omitempty"`
+	// SampleRatio is the share of traces recorded, from 0 to 1
+	SampleRatio *float64 `json:"sampleRatio,omitempty"`
+}
+
+// AuditConfig records every token obtained and the targets it was written
+// to in an append-only file.
+type AuditConfig struct {
+	// File is the audit log, relative to the directory of the config file
+	// unless absolute
 	File string `json:"file"`
-	Key  string `json:"key"`
 }
 
 type OIDCConfig struct {
-	IssuerURL    string   `json:"issuerUrl"`
-	ClientID     string   `json:"clientId"`
-	ClientSecret string   `json:"clientSecret"`
-	Scopes       []string `json:"scopes"`
-	AuthMethod   string   `json:"authMethod"`
+	IssuerURL    string       `json:"issuerUrl"`
+	ClientID     string       `json:"clientId"`
+	ClientSecret string       `json:"clientSecret"`
+	Scopes       []string     `json:"scopes"`
+	AuthMethod   string       `json:"authMethod"`
+	Provider     string       `json:"provider"`
+	Audience     string       `json:"audience,omitempty"`
+	Verify       VerifyConfig `json:"verify"`
+
+	InsecureDiscovery *InsecureDiscoveryConfig `json:"insecureDiscovery,omitempty"`
+}
+
+// VerifyConfig relaxes or tightens ID token verification.
+type VerifyConfig struct {
+	Audiences       []string               `json:"audiences,omitempty"`
+	Algorithms      []string               `json:"algorithms,omitempty"`
+	ClockSkew       string                 `json:"clockSkew,omitempty"`
+	SkipExpiryCheck bool                   `json:"skipExpiryCheck,omitempty"`
+	RequiredClaims  map[string]interface{} `json:"requiredClaims,omitempty"`
+}
+
+// InsecureDiscoveryConfig discovers the provider through a URL other than its
+// issuer. It relaxes the usual discovery guarantees and is meant for local
+// IdPs whose advertised hostname is not reachable from the host.
+type InsecureDiscoveryConfig struct {
+	URL          string `json:"url"`
+	RewriteHosts bool   `json:"rewriteHosts"`
 }
 
 type UserConfig struct {
@@ -42,6 +171,14 @@ type UserConfig struct {
 	Password string `json:"password,omitempty"`
 }
 
+// SAMLConfig selects the SAML 2.0 bearer assertion grant (RFC 7522) and where
+// the assertion is read from. Exactly one source must be set.
+type SAMLConfig struct {
+	AssertionFile    string   `json:"assertionFile,omitempty"`
+	AssertionCommand []string `json:"assertionCommand,omitempty"`
+	AssertionStdin   bool     `json:"assertionStdin,omitempty"`
+}
+
 func Load(path string) (*Config, error) {
 	ctx := cuecontext.New()
 
@@ -104,6 +241,27 @@ func Load(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// SameAuth reports whether both configs authenticate identically, in which
+// case an existing OIDC client and its session can be kept.
+func (c *Config) SameAuth(other *Config) bool {
+	return reflect.DeepEqual(c.OIDC, other.OIDC) &&
+		reflect.DeepEqual(c.User, other.User) &&
+		reflect.DeepEqual(c.SAML, other.SAML)
+}
+
+// GrantType returns the grant used to obtain new tokens: "saml2_bearer",
+// "password" or "client_credentials".
+func (c *Config) GrantType() string {
+	switch {
+	case c.SAML != nil:
+		return "saml2_bearer"
+	case c.User.Username != "" && c.User.Password != "":
+		return "password"
+	default:
+		return "client_credentials"
+	}
+}
+
 func processEnvRefs(v interface{}) error {
 	switch t := v.(type) {
 	case map[string]interface{}:
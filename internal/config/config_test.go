@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -36,6 +37,22 @@ targets: [
 		t.Errorf("expected 2 targets, got %d", len(cfg.Targets))
 	}
 
+	if cfg.OnExit != "keep" {
+		t.Errorf("expected default onExit keep, got %q", cfg.OnExit)
+	}
+	if cfg.Retry.BaseDelay != "10s" || cfg.Retry.MaxDelay != "5m" || cfg.Retry.Multiplier != 2 || cfg.Retry.Jitter != 0.2 {
+		t.Errorf("unexpected default retry: %+v", cfg.Retry)
+	}
+	if cfg.Refresh.Buffer != "60s" || cfg.Refresh.MinInterval != "10s" || cfg.Refresh.UnknownLifetime != "5m" || cfg.Refresh.LifetimePercent != 0 {
+		t.Errorf("unexpected default refresh: %+v", cfg.Refresh)
+	}
+	if cfg.Clock.WarnSkewAbove != "30s" {
+		t.Errorf("expected default clock.warnSkewAbove 30s, got %q", cfg.Clock.WarnSkewAbove)
+	}
+	if cfg.Alerts.ExpiringWithin != "5m" || len(cfg.Alerts.Webhooks) != 0 {
+		t.Errorf("unexpected default alerts: %+v", cfg.Alerts)
+	}
+
 	if cfg.Targets[0].File != ".env.1" || cfg.Targets[0].Key != "KEY1" {
 		t.Errorf("unexpected target 0: %+v", cfg.Targets[0])
 	}
@@ -81,4 +98,124 @@ user: {
 	if cfg.User.Password != expectedSecret {
 		t.Errorf("expected User password %q, got %q", expectedSecret, cfg.User.Password)
 	}
-}
\ No newline at end of file
+}
+
+func TestLoad_Verify(t *testing.T) {
+	content := `
+package config
+
+oidc: {
+	issuerUrl: "https://example.com"
+	clientId: "client"
+	clientSecret: "secret"
+	verify: {
+		audiences: ["account"]
+		algorithms: ["RS256", "ES256"]
+		clockSkew: "30s"
+		requiredClaims: {
+			tenant: "acme"
+			email_verified: true
+		}
+	}
+}
+`
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "authk.cue")
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	cfg, err := Load(configFile)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	verify := cfg.OIDC.Verify
+	if len(verify.Audiences) != 1 || verify.Audiences[0] != "account" {
+		t.Errorf("unexpected audiences: %v", verify.Audiences)
+	}
+	if len(verify.Algorithms) != 2 {
+		t.Errorf("unexpected algorithms: %v", verify.Algorithms)
+	}
+	if verify.ClockSkew != "30s" {
+		t.Errorf("expected clockSkew 30s, got %q", verify.ClockSkew)
+	}
+	if verify.RequiredClaims["tenant"] != "acme" || verify.RequiredClaims["email_verified"] != true {
+		t.Errorf("unexpected required claims: %v", verify.RequiredClaims)
+	}
+
+	content = strings.Replace(content, `"ES256"`, `"HS256"`, 1)
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	if _, err := Load(configFile); err == nil {
+		t.Error("Load() expected error for unsupported algorithm, got nil")
+	}
+}
+
+func TestLoad_OnUpdate(t *testing.T) {
+	content := `
+package config
+
+oidc: {
+	issuerUrl: "https://example.com"
+	clientId: "client"
+	clientSecret: "secret"
+}
+targets: [
+	{
+		file: ".env"
+		key: "TOKEN"
+		onUpdate: [{command: ["docker", "compose", "restart", "api"]}]
+	},
+]
+onUpdate: [{signal: "HUP", pidFile: "/run/app.pid", timeout: "5s"}]
+`
+	tmpDir := t.TempDir()
+	configFile := filepath.Join(tmpDir, "authk.cue")
+	if err := os.WriteFile(configFile, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	cfg, err := Load(configFile)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	targetHooks := cfg.Targets[0].OnUpdate
+	if len(targetHooks) != 1 || len(targetHooks[0].Command) != 4 {
+		t.Fatalf("unexpected target hooks: %+v", targetHooks)
+	}
+	if targetHooks[0].Token != "env" || targetHooks[0].Timeout != "30s" {
+		t.Errorf("expected default token env and timeout 30s, got %+v", targetHooks[0])
+	}
+	if len(cfg.OnUpdate) != 1 || cfg.OnUpdate[0].Signal != "HUP" || cfg.OnUpdate[0].Timeout != "5s" {
+		t.Errorf("unexpected config-wide hooks: %+v", cfg.OnUpdate)
+	}
+}
+
+func TestConfig_SameAuth(t *testing.T) {
+	base := Config{
+		OIDC:    OIDCConfig{IssuerURL: "https://example.com", ClientID: "client", Scopes: []string{"openid"}},
+		Targets: []Target{{File: ".env", Key: "TOKEN"}},
+	}
+
+	targetsOnly := base
+	targetsOnly.Targets = append([]Target{}, base.Targets...)
+	targetsOnly.Targets = append(targetsOnly.Targets, Target{File: ".env.2", Key: "TOKEN"})
+	if !base.SameAuth(&targetsOnly) {
+		t.Error("SameAuth() = false for a targets-only change")
+	}
+
+	newSecret := base
+	newSecret.OIDC.ClientSecret = "rotated"
+	if base.SameAuth(&newSecret) {
+		t.Error("SameAuth() = true for a changed client secret")
+	}
+
+	withUser := base
+	withUser.User = UserConfig{Username: "user", Password: "pass"}
+	if base.SameAuth(&withUser) {
+		t.Error("SameAuth() = true for added user credentials")
+	}
+}
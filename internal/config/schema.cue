@@ -6,14 +6,121 @@ oidc: {
 	clientSecret: string
 	scopes:       [...string] | *["openid", "profile", "email"]
 	authMethod:   "basic" | "post" | *"basic"
+	provider:     *"auto" | "generic" | "keycloak" | "auth0" | "entra" | "okta" | "authentik" | "zitadel"
+	audience?:    string
+
+	// ID token verification, on top of the signature and issuer checks
+	verify?: {
+		// Audiences accepted in addition to clientId
+		audiences?: [...string]
+		// Allowed signing algorithms, defaults to those the provider advertises
+		algorithms?: [...("RS256" | "RS384" | "RS512" | "ES256" | "ES384" | "ES512" | "PS256" | "PS384" | "PS512" | "EdDSA")]
+		// Tolerated clock skew on expiry, as a Go duration such as "30s"
+		clockSkew?: string
+		// Accept expired ID tokens, for diagnostics only
+		skipExpiryCheck?: bool
+		// Claims that must be present with the given values
+		requiredClaims?: [string]: _
+	}
+
+	// Relaxed-security mode: discover through url while still requiring the
+	// advertised issuer to equal issuerUrl. With rewriteHosts, endpoints on
+	// the issuer's host are called on the host of url instead.
+	insecureDiscovery?: {
+		url:          string
+		rewriteHosts: bool | *true
+	}
 }
 user: {
 	username?: string
 	password?: string
 }
+saml?: {
+	assertionFile?:    string
+	assertionCommand?: [...string]
+	assertionStdin?:   bool
+}
 tokenKey: string | *"TOKEN"
 
+// An action run after the token is written: a command, a signal sent to a
+// process, or a file touched
+#Hook: {
+	command?: [...string]
+	token:    *"env" | "stdin" | "none"
+	signal?:  string
+	pid?:     int
+	pidFile?: string
+	touch?:   string
+	timeout:  string | *"30s"
+}
+
 targets?: [...{
-	file: string
-	key:  string
+	file:      string
+	key:       string
+	onUpdate?: [...#Hook]
 }]
+
+// Hooks run once after every token update, when at least one target was written
+onUpdate?: [...#Hook]
+
+// What to do with the token in every target when authk stops
+onExit: *"keep" | "blank" | "remove"
+
+// Backoff when re-authentication fails with a retryable error
+retry: {
+	baseDelay:  string | *"10s"
+	maxDelay:   string | *"5m"
+	multiplier: number & >=1 | *2.0
+	jitter:     number & >=0 & <=1 | *0.2
+}
+
+// When tokens are refreshed. lifetimePercent, when set, replaces buffer.
+refresh: {
+	buffer:           string | *"60s"
+	lifetimePercent?: number & >0 & <100
+	minInterval:      string | *"10s"
+	unknownLifetime:  string | *"5m"
+}
+
+// Clock skew against the IdP is measured from the Date header of its
+// responses, or from the iat claim of access tokens
+clock: {
+	warnSkewAbove: string | *"30s"
+}
+
+// A URL receiving notifications. format shapes the body for Slack or Teams
+// incoming webhooks; template, a Go text/template rendering the event,
+// replaces it.
+#Webhook: {
+	url:       string
+	format:    *"json" | "slack" | "teams"
+	template?: string
+	// Events sent, all by default
+	events?: [...("failed" | "expiring" | "recovered" | "stopped")]
+	headers?: [string]: string
+	timeout: string | *"10s"
+}
+
+// Notifications about failures and recovery
+alerts: {
+	// A token that could not be renewed is reported this long before it expires
+	expiringWithin: string | *"5m"
+	webhooks?: [...#Webhook]
+}
+
+// OpenTelemetry tracing of IdP calls and target writes. The standard OTEL_*
+// environment variables apply to settings left out.
+tracing?: {
+	exporter?:    "otlp" | "stdout" | "none"
+	endpoint?:    string
+	protocol?:    "http/protobuf" | "grpc"
+	insecure?:    bool
+	sampleRatio?: number & >=0 & <=1
+}
+
+// Append-only log of the tokens obtained and the targets they were written
+// to, one JSON object per line. Tokens themselves are never recorded.
+audit?: {
+	// Relative to the directory of this file unless absolute
+	file: string
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Watch polls path every interval and sends on the returned channel whenever
+// its modification time or size changes. Polling, rather than file system
+// notifications, keeps working when editors replace the file on save. The
+// channel is closed when ctx is cancelled.
+func Watch(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		defer close(changes)
+
+		last, _ := os.Stat(path)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(path)
+			if err != nil || (last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size()) {
+				continue
+			}
+			last = info
+
+			// Coalesce changes the receiver has not picked up yet
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return changes
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "authk.cue")
+	if err := os.WriteFile(configFile, []byte("package config\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := Watch(ctx, configFile, 10*time.Millisecond)
+
+	select {
+	case <-changes:
+		t.Fatal("Watch() reported a change before the file was modified")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := os.WriteFile(configFile, []byte("package config\n\ntokenKey: \"OTHER\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Watch() did not report the change")
+	}
+
+	cancel()
+	for range changes {
+		// Drain until closed
+	}
+}
@@ -0,0 +1,28 @@
+package daemon
+
+import "time"
+
+// Clock tells the wall-clock time and waits. Tests replace it to control
+// time deterministically.
+type Clock interface {
+	// Now returns the wall-clock time, without a monotonic reading
+	Now() time.Time
+	// After waits for d of monotonic time, which may not advance while the
+	// machine is suspended
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now().Round(0) }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+const (
+	// wakeInterval bounds each wait so that wall-clock deadlines are
+	// noticed soon after the machine resumes from suspend
+	wakeInterval = 30 * time.Second
+	// resumeThreshold is how far the wall clock must run ahead of a wait to
+	// be taken as a resume from suspend
+	resumeThreshold = time.Minute
+)
@@ -0,0 +1,142 @@
+package daemon
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+)
+
+// fakeClock keeps wall-clock and monotonic time apart, so that tests can
+// suspend the machine: Suspend moves the wall clock only, like a laptop lid.
+type fakeClock struct {
+	mu      sync.Mutex
+	wall    time.Time
+	mono    time.Duration
+	timers  []fakeTimer
+	waiting chan struct{}
+}
+
+type fakeTimer struct {
+	deadline time.Duration
+	ch       chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{wall: now, waiting: make(chan struct{}, 16)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.wall
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	ch := make(chan time.Time, 1)
+	c.timers = append(c.timers, fakeTimer{deadline: c.mono + d, ch: ch})
+	c.mu.Unlock()
+	c.waiting <- struct{}{}
+	return ch
+}
+
+// Advance moves both clocks and fires the timers that are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wall = c.wall.Add(d)
+	c.mono += d
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.deadline <= c.mono {
+			timer.ch <- c.wall
+		} else {
+			pending = append(pending, timer)
+		}
+	}
+	c.timers = pending
+}
+
+// Suspend moves the wall clock only.
+func (c *fakeClock) Suspend(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wall = c.wall.Add(d)
+}
+
+func startWithClock(t *testing.T, clock *fakeClock) *fakeClient {
+	t.Helper()
+	client := newFakeClient()
+	d := New(client, Options{
+		Targets: []config.Target{{File: filepath.Join(t.TempDir(), ".env"), Key: "TOKEN"}},
+		Refresh: Schedule{Buffer: time.Minute, MinInterval: 10 * time.Second},
+	})
+	d.clock = clock
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = d.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	if call := <-client.calls; call != "get" {
+		t.Fatalf("expected initial get, got %s", call)
+	}
+	return client
+}
+
+func TestDaemon_Run_WallClockSchedule(t *testing.T) {
+	clock := newFakeClock(time.Now().Round(0))
+	client := startWithClock(t, clock)
+
+	// The token lasts an hour: the refresh is due a minute before expiry
+	start := clock.Now()
+	for {
+		select {
+		case call := <-client.calls:
+			if call != "refresh" {
+				t.Fatalf("expected refresh, got %s", call)
+			}
+			if elapsed := clock.Now().Sub(start); elapsed < 59*time.Minute || elapsed > 60*time.Minute {
+				t.Errorf("refreshed after %s, want 59m", elapsed)
+			}
+			return
+		case <-clock.waiting:
+			if clock.Now().Sub(start) > time.Hour {
+				t.Fatal("token was not refreshed on schedule")
+			}
+			clock.Advance(wakeInterval)
+		case <-time.After(5 * time.Second):
+			t.Fatal("daemon stopped waiting")
+		}
+	}
+}
+
+func TestDaemon_Run_Resume(t *testing.T) {
+	clock := newFakeClock(time.Now().Round(0))
+	client := startWithClock(t, clock)
+
+	// Suspended for ten minutes: the refresh is not due yet, but the token
+	// may be stale and timers lost track, so authk refreshes right away
+	<-clock.waiting
+	clock.Suspend(10 * time.Minute)
+	clock.Advance(wakeInterval)
+
+	select {
+	case call := <-client.calls:
+		if call != "refresh" {
+			t.Fatalf("expected refresh, got %s", call)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("token was not refreshed after resume")
+	}
+}
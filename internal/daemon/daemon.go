@@ -0,0 +1,494 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/codozor/authk/internal/audit"
+	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/env"
+	"github.com/codozor/authk/internal/hooks"
+	"github.com/codozor/authk/internal/metrics"
+	"github.com/codozor/authk/internal/retry"
+	"github.com/codozor/authk/internal/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+)
+
+// Exit policies applied to every target when the daemon stops.
+const (
+	OnExitKeep   = "keep"
+	OnExitBlank  = "blank"
+	OnExitRemove = "remove"
+)
+
+// TokenClient obtains and refreshes tokens. It is implemented by *oidc.Client.
+type TokenClient interface {
+	GetToken(ctx context.Context, username, password string) (*oauth2.Token, error)
+	RefreshToken(ctx context.Context, token *oauth2.Token) (*oauth2.Token, error)
+	RefreshExpiry(token *oauth2.Token) time.Time
+	// ClockSkew is the offset between the IdP clock and the local clock,
+	// positive when the IdP is ahead
+	ClockSkew() time.Duration
+}
+
+// Options are the daemon settings that can change on reload.
+type Options struct {
+	Targets []config.Target
+	// OnExit is the policy applied to every target when the daemon stops
+	OnExit string
+	// Retry is the backoff applied when re-authentication fails
+	Retry retry.Policy
+	// Refresh decides when tokens are refreshed
+	Refresh Schedule
+	// Hooks run after targets are written
+	Hooks *hooks.Set
+	// Audit records the tokens obtained and the targets they were written to
+	Audit *audit.Log
+	// Notify, when set, is called with every new token once the targets
+	// are written
+	Notify func(token *oauth2.Token)
+}
+
+// Daemon keeps a valid token in every target until its context is cancelled.
+type Daemon struct {
+	client TokenClient
+	opts   Options
+	clock  Clock
+
+	// Reload hands a new state to the loop through pending, Refresh wakes
+	// it up through requested
+	mu             sync.Mutex
+	pending        *reload
+	reloaded       chan struct{}
+	reauthenticate bool
+	requested      chan struct{}
+
+	// state is reported through Status, token through Token
+	stateMu sync.Mutex
+	state   Status
+	token   *oauth2.Token
+}
+
+// reload is a state change requested through Reload.
+type reload struct {
+	client TokenClient
+	opts   Options
+	// applied reports whether the reload took effect as requested
+	applied chan bool
+}
+
+func New(client TokenClient, opts Options) *Daemon {
+	return &Daemon{
+		client:    client,
+		opts:      opts,
+		clock:     realClock{},
+		reloaded:  make(chan struct{}, 1),
+		requested: make(chan struct{}, 1),
+	}
+}
+
+// Reload replaces the options of a running daemon, and its client unless
+// client is nil. A new client re-authenticates immediately; the previous
+// client is kept if that fails. The returned channel receives true once the
+// reload was applied, or false if the previous client was kept or another
+// reload replaced this one first.
+func (d *Daemon) Reload(client TokenClient, opts Options) <-chan bool {
+	applied := make(chan bool, 1)
+	d.mu.Lock()
+	if d.pending != nil {
+		d.pending.applied <- false
+	}
+	d.pending = &reload{client: client, opts: opts, applied: applied}
+	d.mu.Unlock()
+
+	select {
+	case d.reloaded <- struct{}{}:
+	default:
+	}
+	return applied
+}
+
+// Refresh makes a running daemon renew its token now instead of waiting for
+// the next scheduled refresh, with a full re-authentication instead of the
+// refresh token when reauthenticate is set.
+func (d *Daemon) Refresh(reauthenticate bool) {
+	d.mu.Lock()
+	d.reauthenticate = d.reauthenticate || reauthenticate
+	d.mu.Unlock()
+
+	select {
+	case d.requested <- struct{}{}:
+	default:
+	}
+}
+
+// Run fetches a token, writes it to every target and refreshes it before it
+// expires. It returns nil once ctx is cancelled, after applying the exit
+// policy if a token was written. A refresh in flight at that point is
+// aborted and not written.
+//
+// Refreshes are scheduled against the wall clock, so a machine resuming from
+// suspend refreshes right away instead of serving an expired token.
+//
+// Failed re-authentications are retried with backoff, except for errors
+// that retrying cannot fix, such as invalid credentials, which stop the
+// daemon with an error.
+func (d *Daemon) Run(ctx context.Context) error {
+	// Initial Token Retrieval
+	renewCtx, span := tracer.Start(ctx, "authk.renewal", trace.WithAttributes(attrRenewalMethod.String(metrics.MethodInitial)))
+	token, err := d.client.GetToken(renewCtx, "", "")
+	if ctx.Err() == nil {
+		metrics.ObserveRenewal(metrics.MethodInitial, err)
+	}
+	if err != nil {
+		tracing.End(span, err)
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("failed to get initial token: %w", err)
+	}
+
+	issued := d.clock.Now()
+	d.setToken(token, issued)
+	written := d.updateTargets(renewCtx, d.opts.Targets, token)
+	d.record(audit.EventIssued, token, written)
+	tracing.End(span, nil)
+	d.notify(token)
+
+	// The exit policy only applies once a token was written, so that an IdP
+	// outage at startup leaves the previous, possibly still valid, token alone
+	defer func() {
+		log.Info().Str("on_exit", d.opts.OnExit).Msg("Shutting down")
+		d.exit(d.opts.Targets)
+	}()
+
+	// Maintenance Loop
+	attempt := 0
+	var retryDelay time.Duration
+	for {
+		var refreshAt time.Time
+		if attempt > 0 {
+			refreshAt = d.clock.Now().Add(retryDelay)
+			log.Info().Int("attempt", attempt).Dur("sleep_duration", retryDelay).Msg("Waiting before retrying")
+		} else {
+			var basis string
+			skew := d.client.ClockSkew()
+			refreshAt, basis = d.opts.Refresh.Next(issued, token, skew)
+			event := log.Info().Time("refresh_at", refreshAt).Str("basis", basis).Dur("sleep_duration", refreshAt.Sub(d.clock.Now()))
+			if !token.Expiry.IsZero() {
+				event = event.Dur("lifetime", token.Expiry.Sub(issued))
+			}
+			if skew != 0 {
+				event = event.Dur("clock_skew", skew)
+			}
+			event.Msg("Refresh scheduled")
+		}
+		d.updateState(func(s *Status) { s.NextRefresh = refreshAt })
+		reauthenticate := false
+		switch d.wait(ctx, refreshAt) {
+		case waitRequested:
+			d.mu.Lock()
+			reauthenticate = d.reauthenticate
+			d.reauthenticate = false
+			d.mu.Unlock()
+			log.Info().Bool("reauthenticate", reauthenticate).Msg("Renewal requested")
+		case waitCancelled:
+			return nil
+		case waitReloaded:
+			var reauthenticated bool
+			token, reauthenticated = d.applyReload(ctx, token)
+			if reauthenticated {
+				issued = d.clock.Now()
+				d.setToken(token, issued)
+				d.notify(token)
+			}
+			attempt = 0
+			continue
+		}
+
+		// Attempt to refresh the token, unless a re-authentication was
+		// requested, the token came without a refresh token, as with
+		// client_credentials, or the provider reported that the refresh token
+		// itself has already expired
+		if !reauthenticate && token.RefreshToken == "" {
+			log.Debug().Msg("No refresh token, re-authenticating")
+			reauthenticate = true
+		}
+		renewCtx, span := tracer.Start(ctx, "authk.renewal", trace.WithAttributes(attrRenewalMethod.String(metrics.MethodRefresh)))
+		event := audit.EventRefreshed
+		var newToken *oauth2.Token
+		switch refreshExpiry := d.client.RefreshExpiry(token); {
+		case reauthenticate:
+		case !refreshExpiry.IsZero() && d.clock.Now().After(refreshExpiry):
+			err = fmt.Errorf("refresh token expired at %s", refreshExpiry.Format(time.RFC3339))
+		default:
+			newToken, err = d.client.RefreshToken(renewCtx, token)
+		}
+		if !reauthenticate && ctx.Err() == nil {
+			metrics.ObserveRenewal(metrics.MethodRefresh, err)
+		}
+		if (reauthenticate || err != nil) && ctx.Err() == nil {
+			if !reauthenticate {
+				log.Error().Err(err).Msg("Failed to refresh token, attempting full re-authentication")
+			}
+
+			// Try full re-authentication
+			span.SetAttributes(attrRenewalMethod.String(metrics.MethodReauthentication))
+			event = audit.EventIssued
+			newToken, err = d.client.GetToken(renewCtx, "", "")
+			if ctx.Err() == nil {
+				metrics.ObserveRenewal(metrics.MethodReauthentication, err)
+			}
+			if err != nil && ctx.Err() == nil {
+				tracing.End(span, err)
+				class := retry.Classify(err)
+				if !class.Retryable {
+					log.Error().Err(err).Str("reason", class.Reason).Msg("Failed to re-authenticate, not retrying")
+					return fmt.Errorf("failed to re-authenticate: %w", err)
+				}
+
+				attempt++
+				retryDelay = d.opts.Retry.Delay(attempt)
+				if class.RetryAfter > retryDelay {
+					retryDelay = class.RetryAfter
+				}
+				log.Error().Err(err).Str("reason", class.Reason).Int("attempt", attempt).Msg("Failed to re-authenticate")
+				d.updateState(func(s *Status) {
+					s.LastError = err.Error()
+					s.LastErrorAt = d.clock.Now()
+					s.Attempt = attempt
+				})
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			tracing.End(span, ctx.Err())
+			return nil
+		}
+
+		// Update token
+		token = newToken
+		issued = d.clock.Now()
+		attempt = 0
+		d.setToken(token, issued)
+
+		written := d.updateTargets(renewCtx, d.opts.Targets, token)
+		d.record(event, token, written)
+		tracing.End(span, nil)
+		d.notify(token)
+	}
+}
+
+// Sync obtains a token and writes it to every target once, running the
+// hooks, without maintaining it afterwards. Targets that could not be written
+// have an error in the returned status.
+func (d *Daemon) Sync(ctx context.Context) (Status, error) {
+	ctx, span := tracer.Start(ctx, "authk.renewal", trace.WithAttributes(attrRenewalMethod.String(metrics.MethodInitial)))
+	token, err := d.client.GetToken(ctx, "", "")
+	if err != nil {
+		tracing.End(span, err)
+		return d.Status(), fmt.Errorf("failed to authenticate: %w", err)
+	}
+	d.setToken(token, d.clock.Now())
+	written := d.updateTargets(ctx, d.opts.Targets, token)
+	d.record(audit.EventIssued, token, written)
+	tracing.End(span, nil)
+	return d.Status(), nil
+}
+
+// applyReload switches to the pending state and returns the token to
+// maintain from now on, and whether it was just obtained.
+func (d *Daemon) applyReload(ctx context.Context, token *oauth2.Token) (*oauth2.Token, bool) {
+	d.mu.Lock()
+	pending := d.pending
+	d.pending = nil
+	d.mu.Unlock()
+	if pending == nil {
+		return token, false
+	}
+
+	reauthenticated := false
+	if pending.client != nil {
+		log.Info().Msg("OIDC settings changed, re-authenticating")
+		newToken, err := pending.client.GetToken(ctx, "", "")
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to authenticate with reloaded settings, keeping previous client")
+		} else {
+			d.client = pending.client
+			token = newToken
+			reauthenticated = true
+		}
+	}
+
+	// Targets that are no longer maintained get the exit policy now
+	var removed []config.Target
+	for _, old := range d.opts.Targets {
+		if !containsTarget(pending.opts.Targets, old) {
+			removed = append(removed, old)
+		}
+	}
+	previous := d.opts.Targets
+	d.opts = pending.opts
+	d.exit(removed)
+
+	log.Info().Int("count", len(d.opts.Targets)).Msg("Targets reloaded")
+	written := d.updateTargets(ctx, d.opts.Targets, token)
+	if reauthenticated {
+		d.record(audit.EventIssued, token, written)
+	} else {
+		// The current token is already recorded for the previous files
+		var added []string
+		for _, file := range written {
+			covered := slices.ContainsFunc(previous, func(t config.Target) bool { return t.File == file })
+			if !covered && !slices.Contains(added, file) {
+				added = append(added, file)
+			}
+		}
+		if len(added) > 0 {
+			d.record(audit.EventWritten, token, added)
+		}
+	}
+
+	pending.applied <- pending.client == nil || reauthenticated
+	return token, reauthenticated
+}
+
+// setToken records a newly obtained token, which ends any retry sequence.
+func (d *Daemon) setToken(token *oauth2.Token, issued time.Time) {
+	metrics.SetTokenExpiry(token.Expiry)
+	d.stateMu.Lock()
+	d.token = token
+	d.stateMu.Unlock()
+	d.updateState(func(s *Status) {
+		s.Expiry = token.Expiry
+		s.IssuedAt = issued
+		s.LastError = ""
+		s.LastErrorAt = time.Time{}
+		s.Attempt = 0
+	})
+}
+
+// notify hands a new token to Options.Notify.
+func (d *Daemon) notify(token *oauth2.Token) {
+	if d.opts.Notify != nil {
+		d.opts.Notify(token)
+	}
+}
+
+// updateTargets writes token to every target and runs the hooks of those
+// written, then the config-wide hooks if any target was written. It returns
+// the files written.
+func (d *Daemon) updateTargets(ctx context.Context, targets []config.Target, token *oauth2.Token) []string {
+	var written []string
+	for _, target := range targets {
+		_, span := tracer.Start(ctx, "authk.target.write", trace.WithAttributes(
+			attrTargetFile.String(target.File),
+			attrTargetKey.String(target.Key),
+		))
+		mgr := env.NewManager(target.File, target.Key)
+		err := mgr.Update(token.AccessToken)
+		tracing.End(span, err)
+		if err != nil {
+			log.Error().Err(err).Str("file", target.File).Msg("Failed to update target")
+			metrics.ObserveTargetWriteFailure(target.File)
+		} else {
+			log.Info().Str("file", target.File).Msg("Target updated")
+			d.opts.Hooks.TargetUpdated(ctx, target, token.AccessToken)
+			written = append(written, target.File)
+		}
+		d.setTargetState(targets, target, err)
+	}
+	if len(written) > 0 {
+		d.opts.Hooks.Updated(ctx, token.AccessToken)
+	}
+	return written
+}
+
+// record appends event for token and the target files to the audit log.
+func (d *Daemon) record(event string, token *oauth2.Token, targets []string) {
+	if err := d.opts.Audit.Record(event, token, targets); err != nil {
+		log.Error().Err(err).Str("event", event).Msg("Failed to write audit log")
+	}
+}
+
+// exit applies the exit policy to the given targets.
+func (d *Daemon) exit(targets []config.Target) {
+	var removed []string
+	for _, target := range targets {
+		mgr := env.NewManager(target.File, target.Key)
+
+		var err error
+		switch d.opts.OnExit {
+		case OnExitBlank:
+			err = mgr.Update("")
+		case OnExitRemove:
+			err = mgr.Remove()
+		default:
+			continue
+		}
+		if err != nil {
+			log.Error().Err(err).Str("file", target.File).Msg("Failed to clean up target")
+		} else {
+			log.Info().Str("file", target.File).Msg("Target cleaned up")
+			removed = append(removed, target.File)
+		}
+	}
+
+	if token := d.Token(); token != nil && len(removed) > 0 {
+		d.record(audit.EventRemoved, token, removed)
+	}
+}
+
+func containsTarget(targets []config.Target, target config.Target) bool {
+	for _, t := range targets {
+		if t.Same(target) {
+			return true
+		}
+	}
+	return false
+}
+
+type waitResult int
+
+const (
+	waitElapsed waitResult = iota
+	waitCancelled
+	waitReloaded
+	waitRequested
+)
+
+// wait blocks until the wall clock reaches until, unless ctx is cancelled or
+// a reload or renewal is requested first. Timers do not advance while the
+// machine is suspended, so it wakes up at least every wakeInterval to compare
+// against the wall clock, and returns early when the wall clock jumped
+// forward.
+func (d *Daemon) wait(ctx context.Context, until time.Time) waitResult {
+	for {
+		now := d.clock.Now()
+		remaining := until.Sub(now)
+		if remaining <= 0 {
+			return waitElapsed
+		}
+		step := min(remaining, wakeInterval)
+
+		select {
+		case <-ctx.Done():
+			return waitCancelled
+		case <-d.reloaded:
+			return waitReloaded
+		case <-d.requested:
+			return waitRequested
+		case <-d.clock.After(step):
+		}
+
+		if jump := d.clock.Now().Sub(now) - step; jump > resumeThreshold {
+			log.Warn().Dur("jump", jump).Msg("Wall clock jumped forward, probably resumed from suspend, refreshing now")
+			return waitElapsed
+		}
+	}
+}
@@ -0,0 +1,523 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/audit"
+	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/hooks"
+	"github.com/codozor/authk/internal/metrics"
+	"github.com/codozor/authk/internal/retry"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/oauth2"
+)
+
+// fakeClient hands out tokens and reports each call on calls.
+type fakeClient struct {
+	mu     sync.Mutex
+	tokens int
+	calls  chan string
+
+	// getErr, when set, fails every GetToken
+	getErr error
+	// reauthErr, when set, fails every GetToken after the first
+	reauthErr error
+	// refreshErr, when set, fails refreshes instead of blocking them
+	refreshErr error
+	// noRefreshToken, when set, hands out tokens without a refresh token
+	noRefreshToken bool
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{calls: make(chan string, 16)}
+}
+
+func (c *fakeClient) GetToken(ctx context.Context, username, password string) (*oauth2.Token, error) {
+	c.mu.Lock()
+	c.tokens++
+	n := c.tokens
+	c.mu.Unlock()
+	c.calls <- "get"
+	if c.getErr != nil {
+		return nil, c.getErr
+	}
+	if c.reauthErr != nil && n > 1 {
+		return nil, c.reauthErr
+	}
+	token := &oauth2.Token{
+		AccessToken: fmt.Sprintf("token-%d", n),
+		Expiry:      time.Now().Add(time.Hour),
+	}
+	if !c.noRefreshToken {
+		token.RefreshToken = fmt.Sprintf("refresh-%d", n)
+	}
+	return token, nil
+}
+
+func (c *fakeClient) RefreshToken(ctx context.Context, token *oauth2.Token) (*oauth2.Token, error) {
+	c.calls <- "refresh"
+	if c.refreshErr != nil {
+		return nil, c.refreshErr
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (c *fakeClient) RefreshExpiry(token *oauth2.Token) time.Time {
+	return time.Time{}
+}
+
+func (c *fakeClient) ClockSkew() time.Duration {
+	return 0
+}
+
+func TestDaemon_Run_OnExit(t *testing.T) {
+	tests := []struct {
+		onExit   string
+		expected string
+	}{
+		{onExit: OnExitKeep, expected: "OTHER=foo\nTOKEN=\"token-1\"\n"},
+		{onExit: OnExitBlank, expected: "OTHER=foo\nTOKEN=\"\"\n"},
+		{onExit: OnExitRemove, expected: "OTHER=foo\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.onExit, func(t *testing.T) {
+			envFile := filepath.Join(t.TempDir(), ".env")
+			if err := os.WriteFile(envFile, []byte("OTHER=foo\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			client := newFakeClient()
+			d := New(client, Options{Targets: []config.Target{{File: envFile, Key: "TOKEN"}}, OnExit: tt.onExit})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- d.Run(ctx) }()
+
+			// Stop once the initial token has been written
+			if call := <-client.calls; call != "get" {
+				t.Fatalf("expected initial get, got %s", call)
+			}
+			waitForContent(t, envFile, "token-1")
+			cancel()
+
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("Run() error = %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Run() did not return after cancellation")
+			}
+
+			content, err := os.ReadFile(envFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.expected {
+				t.Errorf("target mismatch:\ngot:\n%s\nwant:\n%s", string(content), tt.expected)
+			}
+		})
+	}
+}
+
+func TestDaemon_Run_InitialErrorKeepsTargets(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(envFile, []byte("TOKEN=\"previous\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newFakeClient()
+	client.getErr = errors.New("connection refused")
+	d := New(client, Options{Targets: []config.Target{{File: envFile, Key: "TOKEN"}}, OnExit: OnExitRemove})
+
+	if err := d.Run(context.Background()); err == nil {
+		t.Fatal("Run() expected error, got nil")
+	}
+
+	content, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "TOKEN=\"previous\"\n"; string(content) != want {
+		t.Errorf("target mismatch:\ngot:\n%s\nwant:\n%s", string(content), want)
+	}
+}
+
+func TestDaemon_Run_Audit(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, ".env")
+	cfg := &config.Config{OIDC: config.OIDCConfig{IssuerURL: "https://idp.example.com"}, Audit: &config.AuditConfig{File: "audit.log"}}
+	auditLog, err := audit.New(cfg, filepath.Join(dir, "authk.cue"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newFakeClient()
+	d := New(client, Options{Targets: []config.Target{{File: envFile, Key: "TOKEN"}}, OnExit: OnExitRemove, Audit: auditLog})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx) }()
+	<-client.calls
+	waitForContent(t, envFile, "token-1")
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	records, _, err := audit.Read(filepath.Join(dir, "audit.log"), audit.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Event != audit.EventIssued || records[1].Event != audit.EventRemoved {
+		t.Fatalf("expected an issued then a removed record, got %+v", records)
+	}
+	for _, r := range records {
+		if len(r.Targets) != 1 || r.Targets[0] != envFile {
+			t.Errorf("%s: targets = %v, want %s", r.Event, r.Targets, envFile)
+		}
+	}
+	if records[0].Grant != "client_credentials" || records[0].Issuer != "https://idp.example.com" {
+		t.Errorf("unexpected record: %+v", records[0])
+	}
+}
+
+func TestDaemon_Refresh(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	client := newFakeClient()
+	d := New(client, Options{Targets: []config.Target{{File: envFile, Key: "TOKEN"}}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx) }()
+	<-client.calls
+	waitForContent(t, envFile, "token-1")
+
+	// The token is valid for an hour, renewals only happen on request
+	d.Refresh(true)
+	if call := <-client.calls; call != "get" {
+		t.Fatalf("expected a re-authentication, got %s", call)
+	}
+	waitForContent(t, envFile, "token-2")
+
+	d.Refresh(false)
+	if call := <-client.calls; call != "refresh" {
+		t.Fatalf("expected a refresh, got %s", call)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+}
+
+func TestDaemon_Refresh_NoRefreshToken(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	client := newFakeClient()
+	client.noRefreshToken = true
+	d := New(client, Options{Targets: []config.Target{{File: envFile, Key: "TOKEN"}}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx) }()
+	<-client.calls
+	waitForContent(t, envFile, "token-1")
+
+	refreshes := counter(t, `authk_renewals_total{method="refresh",outcome="failure"}`)
+	reauthentications := counter(t, `authk_renewals_total{method="reauthentication",outcome="success"}`)
+
+	// Without a refresh token, a refresh goes straight to re-authentication
+	d.Refresh(false)
+	if call := <-client.calls; call != "get" {
+		t.Fatalf("expected a re-authentication, got %s", call)
+	}
+	waitForContent(t, envFile, "token-2")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if got := counter(t, `authk_renewals_total{method="refresh",outcome="failure"}`); got != refreshes {
+		t.Errorf("refresh failures = %v, want %v", got, refreshes)
+	}
+	if got := counter(t, `authk_renewals_total{method="reauthentication",outcome="success"}`); got != reauthentications+1 {
+		t.Errorf("re-authentications = %v, want %v", got, reauthentications+1)
+	}
+}
+
+// counter returns the value of the sample named series served by the metrics
+// handler, zero if it is absent.
+func counter(t *testing.T, series string) float64 {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("failed to parse %s: %v", line, err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func waitForContent(t *testing.T, path, substr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if content, err := os.ReadFile(path); err == nil && strings.Contains(string(content), substr) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s never contained %q", path, substr)
+}
+
+func TestDaemon_Reload(t *testing.T) {
+	tmpDir := t.TempDir()
+	first := filepath.Join(tmpDir, ".env.1")
+	second := filepath.Join(tmpDir, ".env.2")
+
+	client := newFakeClient()
+	d := New(client, Options{Targets: []config.Target{{File: first, Key: "TOKEN"}}, OnExit: OnExitRemove})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx) }()
+
+	<-client.calls
+	waitForContent(t, first, "token-1")
+
+	// Targets only: the current token moves to the new target list and the
+	// dropped target gets the exit policy
+	d.Reload(nil, Options{Targets: []config.Target{{File: second, Key: "TOKEN"}}, OnExit: OnExitRemove})
+	waitForContent(t, second, "token-1")
+	if content, _ := os.ReadFile(first); strings.Contains(string(content), "TOKEN") {
+		t.Errorf("dropped target still contains the token:\n%s", content)
+	}
+
+	// New client: re-authenticates before writing
+	newClient := newFakeClient()
+	newClient.tokens = 41
+	applied := d.Reload(newClient, Options{Targets: []config.Target{{File: second, Key: "TOKEN"}}, OnExit: OnExitKeep})
+	if call := <-newClient.calls; call != "get" {
+		t.Fatalf("expected reloaded client to authenticate, got %s", call)
+	}
+	waitForContent(t, second, "token-42")
+	if !<-applied {
+		t.Error("Reload() reported a rejected client")
+	}
+
+	// A client that fails to authenticate is rejected
+	brokenClient := newFakeClient()
+	brokenClient.getErr = errors.New("invalid_client")
+	if <-d.Reload(brokenClient, Options{Targets: []config.Target{{File: second, Key: "TOKEN"}}, OnExit: OnExitKeep}) {
+		t.Error("Reload() reported a failing client as applied")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+}
+
+func TestDaemon_Reload_Audit(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, ".env.1")
+	second := filepath.Join(dir, ".env.2")
+	cfg := &config.Config{Audit: &config.AuditConfig{File: "audit.log"}}
+	auditLog, err := audit.New(cfg, filepath.Join(dir, "authk.cue"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newFakeClient()
+	d := New(client, Options{Targets: []config.Target{{File: first, Key: "TOKEN"}}, Audit: auditLog})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx) }()
+	<-client.calls
+	waitForContent(t, first, "token-1")
+
+	// The current token is written to the added target only
+	d.Reload(nil, Options{Targets: []config.Target{{File: first, Key: "TOKEN"}, {File: second, Key: "TOKEN"}}, Audit: auditLog})
+	waitForContent(t, second, "token-1")
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	records, _, err := audit.Read(filepath.Join(dir, "audit.log"), audit.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Event != audit.EventIssued || records[1].Event != audit.EventWritten {
+		t.Fatalf("expected an issued then a written record, got %+v", records)
+	}
+	if targets := records[1].Targets; len(targets) != 1 || targets[0] != second {
+		t.Errorf("written targets = %v, want %s", targets, second)
+	}
+}
+
+func TestDaemon_Run_PermanentError(t *testing.T) {
+	client := newFakeClient()
+	client.refreshErr = errors.New("refresh token revoked")
+	client.reauthErr = &oauth2.RetrieveError{
+		Response:  &http.Response{StatusCode: http.StatusBadRequest},
+		ErrorCode: "invalid_grant",
+	}
+
+	d := New(client, Options{
+		Targets: []config.Target{{File: filepath.Join(t.TempDir(), ".env"), Key: "TOKEN"}},
+		OnExit:  OnExitKeep,
+		Retry:   retry.Policy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Multiplier: 1},
+		// Refresh right away
+		Refresh: Schedule{Buffer: 2 * time.Hour, MinInterval: time.Millisecond},
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- d.Run(context.Background()) }()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+			t.Fatalf("Run() error = %v, want invalid_grant", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() kept retrying a permanent error")
+	}
+
+	want := []string{"get", "refresh", "get"}
+	for _, w := range want {
+		if call := <-client.calls; call != w {
+			t.Errorf("expected %s, got %s", w, call)
+		}
+	}
+}
+
+func TestDaemon_Run_Hooks(t *testing.T) {
+	dir := t.TempDir()
+	target := config.Target{
+		File:     filepath.Join(dir, ".env"),
+		Key:      "TOKEN",
+		OnUpdate: []config.Hook{{Touch: filepath.Join(dir, "target-hook")}},
+	}
+	broken := config.Target{File: filepath.Join(dir, "missing", ".env"), Key: "TOKEN"}
+	cfg := &config.Config{
+		Targets:  []config.Target{target, broken},
+		OnUpdate: []config.Hook{{Touch: filepath.Join(dir, "global-hook")}},
+	}
+	hookSet, err := hooks.NewSet(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newFakeClient()
+	d := New(client, Options{Targets: cfg.Targets, Hooks: hookSet})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx) }()
+
+	<-client.calls
+	for _, name := range []string{"target-hook", "global-hook"} {
+		waitForFile(t, filepath.Join(dir, name))
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+}
+
+func waitForFile(t *testing.T, path string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(path); err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s was never created", path)
+}
+
+func TestDaemon_Sync(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	missing := filepath.Join(t.TempDir(), "missing", ".env")
+
+	client := newFakeClient()
+	d := New(client, Options{Targets: []config.Target{{File: envFile, Key: "TOKEN"}, {File: missing, Key: "TOKEN"}}})
+
+	status, err := d.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if got := <-client.calls; got != "get" {
+		t.Errorf("expected a token request, got %s", got)
+	}
+	waitForContent(t, envFile, "token-1")
+
+	if !status.HasToken() || len(status.Targets) != 2 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if status.Targets[0].Error != "" || status.Targets[1].Error == "" {
+		t.Errorf("expected only %s to fail, got %+v", missing, status.Targets)
+	}
+}
+
+func TestDaemon_SyncTraces(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	// Package tracers only follow the first global provider, so it is not
+	// restored
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	envFile := filepath.Join(t.TempDir(), ".env")
+	missing := filepath.Join(t.TempDir(), "missing", ".env")
+	d := New(newFakeClient(), Options{Targets: []config.Target{{File: envFile, Key: "TOKEN"}, {File: missing, Key: "TOKEN"}}})
+	if _, err := d.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	renewal := spans[2]
+	if renewal.Name() != "authk.renewal" {
+		t.Fatalf("expected the renewal span to end last, got %s", renewal.Name())
+	}
+	for i, wantCode := range []codes.Code{codes.Unset, codes.Error} {
+		write := spans[i]
+		if write.Name() != "authk.target.write" || write.Parent().SpanID() != renewal.SpanContext().SpanID() {
+			t.Errorf("expected a target write under the renewal, got %s", write.Name())
+		}
+		if write.Status().Code != wantCode {
+			t.Errorf("%s: status = %v, want %v", write.Name(), write.Status().Code, wantCode)
+		}
+	}
+	for _, attr := range renewal.Attributes() {
+		if strings.Contains(attr.Value.Emit(), "token-1") {
+			t.Errorf("token recorded in attribute %s", attr.Key)
+		}
+	}
+}
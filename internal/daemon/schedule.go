@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"golang.org/x/oauth2"
+)
+
+// Schedule decides when a token is refreshed.
+type Schedule struct {
+	// Buffer is how long before expiry the token is refreshed
+	Buffer time.Duration
+	// LifetimePercent, when above zero, refreshes after this share of the
+	// token lifetime instead of Buffer
+	LifetimePercent float64
+	// MinInterval is the shortest time between two refreshes
+	MinInterval time.Duration
+	// UnknownLifetime is the refresh interval for tokens without expiry
+	UnknownLifetime time.Duration
+}
+
+// NewSchedule builds a Schedule from the refresh section of the config.
+func NewSchedule(cfg config.RefreshConfig) (Schedule, error) {
+	buffer, err := time.ParseDuration(cfg.Buffer)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("invalid refresh.buffer: %w", err)
+	}
+	minInterval, err := time.ParseDuration(cfg.MinInterval)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("invalid refresh.minInterval: %w", err)
+	}
+	unknownLifetime, err := time.ParseDuration(cfg.UnknownLifetime)
+	if err != nil {
+		return Schedule{}, fmt.Errorf("invalid refresh.unknownLifetime: %w", err)
+	}
+	if unknownLifetime <= 0 {
+		return Schedule{}, fmt.Errorf("refresh.unknownLifetime must be positive, got %s", unknownLifetime)
+	}
+
+	return Schedule{
+		Buffer:          buffer,
+		LifetimePercent: cfg.LifetimePercent,
+		MinInterval:     minInterval,
+		UnknownLifetime: unknownLifetime,
+	}, nil
+}
+
+// Next returns when token, obtained at issued, should be refreshed, and a
+// short description of how that time was chosen. skew is the offset between
+// the IdP clock and the local clock.
+func (s Schedule) Next(issued time.Time, token *oauth2.Token, skew time.Duration) (time.Time, string) {
+	var at time.Time
+	var basis string
+
+	// The expiry is on the local clock, but services on this machine check
+	// the exp claim against it: when the local clock is ahead of the IdP,
+	// they see the token expire that much earlier. When it is behind, they
+	// see it expire later, while the IdP and remote services still see it
+	// expire at token.Expiry, which is therefore kept.
+	expiry := token.Expiry
+	if skew < 0 && !expiry.IsZero() {
+		expiry = expiry.Add(skew)
+	}
+
+	switch {
+	case expiry.IsZero():
+		// Without expires_in or a JWT exp claim, the lifetime is unknown
+		at = issued.Add(s.UnknownLifetime)
+		basis = "unknown lifetime"
+	case s.LifetimePercent > 0:
+		lifetime := expiry.Sub(issued)
+		at = issued.Add(time.Duration(float64(lifetime) * s.LifetimePercent / 100))
+		basis = fmt.Sprintf("%g%% of lifetime", s.LifetimePercent)
+	default:
+		at = expiry.Add(-s.Buffer)
+		basis = fmt.Sprintf("%s before expiry", s.Buffer)
+	}
+
+	if earliest := issued.Add(s.MinInterval); at.Before(earliest) {
+		at = earliest
+		basis = fmt.Sprintf("minimum interval of %s", s.MinInterval)
+	}
+
+	return at, basis
+}
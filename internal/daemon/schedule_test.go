@@ -0,0 +1,71 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"golang.org/x/oauth2"
+)
+
+func TestSchedule_Next(t *testing.T) {
+	issued := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	schedule := Schedule{
+		Buffer:          time.Minute,
+		MinInterval:     10 * time.Second,
+		UnknownLifetime: 5 * time.Minute,
+	}
+
+	tests := []struct {
+		name     string
+		schedule Schedule
+		expiry   time.Time
+		skew     time.Duration
+		want     time.Time
+	}{
+		{name: "Buffer", schedule: schedule, expiry: issued.Add(time.Hour), want: issued.Add(59 * time.Minute)},
+		{name: "Unknown lifetime", schedule: schedule, want: issued.Add(5 * time.Minute)},
+		{name: "Short lifetime", schedule: schedule, expiry: issued.Add(30 * time.Second), want: issued.Add(10 * time.Second)},
+		{name: "Already expired", schedule: schedule, expiry: issued.Add(-time.Hour), want: issued.Add(10 * time.Second)},
+		{
+			name:     "Lifetime percent",
+			schedule: Schedule{Buffer: time.Minute, LifetimePercent: 75, MinInterval: 10 * time.Second},
+			expiry:   issued.Add(time.Hour),
+			want:     issued.Add(45 * time.Minute),
+		},
+		{
+			name:     "Local clock ahead",
+			schedule: schedule,
+			expiry:   issued.Add(time.Hour),
+			skew:     -5 * time.Minute,
+			want:     issued.Add(54 * time.Minute),
+		},
+		{name: "Local clock behind", schedule: schedule, expiry: issued.Add(time.Hour), skew: 5 * time.Minute, want: issued.Add(59 * time.Minute)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, basis := tt.schedule.Next(issued, &oauth2.Token{Expiry: tt.expiry}, tt.skew)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next() = %s (%s), want %s", got, basis, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSchedule(t *testing.T) {
+	s, err := NewSchedule(config.RefreshConfig{Buffer: "60s", MinInterval: "10s", UnknownLifetime: "5m", LifetimePercent: 80})
+	if err != nil {
+		t.Fatalf("NewSchedule() error = %v", err)
+	}
+	if s.Buffer != time.Minute || s.MinInterval != 10*time.Second || s.UnknownLifetime != 5*time.Minute || s.LifetimePercent != 80 {
+		t.Errorf("unexpected schedule: %+v", s)
+	}
+
+	if _, err := NewSchedule(config.RefreshConfig{Buffer: "soon", MinInterval: "10s", UnknownLifetime: "5m"}); err == nil {
+		t.Error("NewSchedule() expected error for invalid buffer, got nil")
+	}
+	if _, err := NewSchedule(config.RefreshConfig{Buffer: "60s", MinInterval: "10s", UnknownLifetime: "0s"}); err == nil {
+		t.Error("NewSchedule() expected error for zero unknownLifetime, got nil")
+	}
+}
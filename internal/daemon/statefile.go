@@ -0,0 +1,80 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// publishInterval is how often PublishStatus checks for state changes.
+const publishInterval = time.Second
+
+// PublishStatus writes the daemon state as JSON to path whenever it changes,
+// until ctx is cancelled, then removes the file. It lets other processes,
+// such as authk status, report on the daemon.
+func (d *Daemon) PublishStatus(ctx context.Context, path string) {
+	defer func() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Warn().Err(err).Msg("Failed to remove state file")
+		}
+	}()
+
+	var last []byte
+	ticker := time.NewTicker(publishInterval)
+	defer ticker.Stop()
+	for {
+		data, err := json.Marshal(d.Status())
+		if err == nil && !bytes.Equal(data, last) {
+			if err = writeFileAtomic(path, data); err == nil {
+				last = data
+			}
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("file", path).Msg("Failed to publish state")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReadStatus reads a state file written by PublishStatus.
+func ReadStatus(path string) (Status, error) {
+	var status Status
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return status, err
+	}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return status, fmt.Errorf("failed to parse state file: %w", err)
+	}
+	return status, nil
+}
+
+// writeFileAtomic replaces path with data, so that readers never see a
+// partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
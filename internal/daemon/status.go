@@ -0,0 +1,117 @@
+package daemon
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"golang.org/x/oauth2"
+)
+
+// Status is a snapshot of the daemon state.
+type Status struct {
+	// Expiry is when the current token expires, zero if unknown
+	Expiry time.Time `json:"expiry,omitzero"`
+	// IssuedAt is when the current token was obtained
+	IssuedAt time.Time `json:"issuedAt,omitzero"`
+	// NextRefresh is when the next refresh or retry is scheduled
+	NextRefresh time.Time `json:"nextRefresh,omitzero"`
+	// LastError is the error of the last failed re-authentication, cleared
+	// once a token is obtained again
+	LastError string `json:"lastError,omitempty"`
+	// LastErrorAt is when LastError happened
+	LastErrorAt time.Time `json:"lastErrorAt,omitzero"`
+	// Attempt is the number of failed re-authentications in a row
+	Attempt int            `json:"attempt"`
+	Targets []TargetStatus `json:"targets"`
+}
+
+// TargetStatus is the state of a single target.
+type TargetStatus struct {
+	File string `json:"file"`
+	Key  string `json:"key"`
+	// UpdatedAt is when the token was last written to the target
+	UpdatedAt time.Time `json:"updatedAt,omitzero"`
+	// Error is the error of the last write, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// HasToken reports whether a token has been obtained.
+func (s Status) HasToken() bool {
+	return !s.IssuedAt.IsZero()
+}
+
+// Status returns a snapshot of the daemon state. It is safe to call while
+// the daemon runs.
+func (d *Daemon) Status() Status {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+
+	status := d.state
+	status.Targets = append([]TargetStatus(nil), d.state.Targets...)
+	return status
+}
+
+// Token returns the current token, or nil before the first one is obtained.
+// It is safe to call while the daemon runs.
+func (d *Daemon) Token() *oauth2.Token {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	return d.token
+}
+
+// updateState applies fn to the daemon state.
+func (d *Daemon) updateState(fn func(*Status)) {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	fn(&d.state)
+}
+
+// setTargetState records the outcome of writing to target.
+func (d *Daemon) setTargetState(targets []config.Target, target config.Target, err error) {
+	d.updateState(func(s *Status) {
+		// Keep the list in step with the targets being maintained
+		current := make([]TargetStatus, 0, len(targets))
+		for _, t := range targets {
+			status := TargetStatus{File: t.File, Key: t.Key}
+			for _, old := range s.Targets {
+				if t.Same(config.Target{File: old.File, Key: old.Key}) {
+					status = old
+				}
+			}
+			if t.Same(target) {
+				if err != nil {
+					status.Error = err.Error()
+				} else {
+					status.Error = ""
+					status.UpdatedAt = d.clock.Now()
+				}
+			}
+			current = append(current, status)
+		}
+		s.Targets = current
+	})
+}
+
+// Ready reports whether the current token is valid for at least minTTL at
+// now, the last re-authentication succeeded and every target was written.
+// When not ready, it also returns the reason.
+func (s Status) Ready(now time.Time, minTTL time.Duration) (bool, string) {
+	if !s.HasToken() {
+		return false, "no token obtained yet"
+	}
+	if s.LastError != "" {
+		return false, fmt.Sprintf("re-authentication failed %d times: %s", s.Attempt, s.LastError)
+	}
+	if !s.Expiry.IsZero() {
+		if ttl := s.Expiry.Sub(now); ttl < minTTL {
+			return false, fmt.Sprintf("token expires in %s", ttl.Round(time.Second))
+		}
+	}
+	for _, target := range s.Targets {
+		if target.Error != "" {
+			return false, fmt.Sprintf("failed to write %s: %s", target.File, target.Error)
+		}
+	}
+	return true, ""
+}
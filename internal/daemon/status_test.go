@@ -0,0 +1,135 @@
+package daemon
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+)
+
+func TestStatus_Ready(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	healthy := Status{
+		IssuedAt: now.Add(-time.Minute),
+		Expiry:   now.Add(time.Hour),
+		Targets:  []TargetStatus{{File: ".env", Key: "TOKEN", UpdatedAt: now.Add(-time.Minute)}},
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Status)
+		ready  bool
+		reason string
+	}{
+		{name: "Healthy", modify: func(s *Status) {}, ready: true},
+		{name: "No token", modify: func(s *Status) { *s = Status{} }, reason: "no token"},
+		{name: "Unknown expiry", modify: func(s *Status) { s.Expiry = time.Time{} }, ready: true},
+		{name: "Expiring", modify: func(s *Status) { s.Expiry = now.Add(10 * time.Second) }, reason: "expires in 10s"},
+		{name: "Retrying", modify: func(s *Status) { s.LastError = "connection refused"; s.Attempt = 3 }, reason: "failed 3 times"},
+		{name: "Target not written", modify: func(s *Status) { s.Targets[0].Error = "permission denied" }, reason: "failed to write .env"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := healthy
+			status.Targets = append([]TargetStatus(nil), healthy.Targets...)
+			tt.modify(&status)
+
+			ready, reason := status.Ready(now, 30*time.Second)
+			if ready != tt.ready {
+				t.Errorf("Ready() = %v (%s), want %v", ready, reason, tt.ready)
+			}
+			if !strings.Contains(reason, tt.reason) {
+				t.Errorf("Ready() reason = %q, want it to contain %q", reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestDaemon_Status(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), ".env")
+	missing := filepath.Join(t.TempDir(), "missing", ".env")
+
+	client := newFakeClient()
+	d := New(client, Options{Targets: []config.Target{{File: envFile, Key: "TOKEN"}, {File: missing, Key: "TOKEN"}}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- d.Run(ctx) }()
+
+	<-client.calls
+	waitForContent(t, envFile, "token-1")
+
+	deadline := time.Now().Add(5 * time.Second)
+	var status Status
+	for time.Now().Before(deadline) {
+		if status = d.Status(); !status.NextRefresh.IsZero() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !status.HasToken() || status.Expiry.IsZero() {
+		t.Errorf("expected a token in status, got %+v", status)
+	}
+	if len(status.Targets) != 2 {
+		t.Fatalf("expected 2 targets, got %+v", status.Targets)
+	}
+	if status.Targets[0].UpdatedAt.IsZero() || status.Targets[0].Error != "" {
+		t.Errorf("expected %s to be written, got %+v", envFile, status.Targets[0])
+	}
+	if token := d.Token(); token == nil || token.AccessToken != "token-1" {
+		t.Errorf("Token() = %v, want token-1", token)
+	}
+	if status.Targets[1].Error == "" {
+		t.Errorf("expected an error writing %s, got %+v", missing, status.Targets[1])
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+}
+
+func TestDaemon_PublishStatus(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	d := New(newFakeClient(), Options{})
+	d.updateState(func(s *Status) {
+		s.IssuedAt = time.Now()
+		s.LastError = "connection refused"
+		s.Attempt = 2
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.PublishStatus(ctx, statePath)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	var status Status
+	var err error
+	for time.Now().Before(deadline) {
+		if status, err = ReadStatus(statePath); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("ReadStatus() error = %v", err)
+	}
+	if !status.HasToken() || status.LastError != "connection refused" || status.Attempt != 2 {
+		t.Errorf("ReadStatus() = %+v", status)
+	}
+
+	cancel()
+	<-done
+	if _, err := ReadStatus(statePath); err == nil {
+		t.Error("state file still exists after PublishStatus returned")
+	}
+}
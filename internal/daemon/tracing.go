@@ -0,0 +1,15 @@
+package daemon
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var tracer = otel.Tracer("github.com/codozor/authk/internal/daemon")
+
+// Span attributes.
+const (
+	attrRenewalMethod = attribute.Key("authk.renewal.method")
+	attrTargetFile    = attribute.Key("authk.target.file")
+	attrTargetKey     = attribute.Key("authk.target.key")
+)
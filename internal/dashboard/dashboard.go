@@ -0,0 +1,157 @@
+// Package dashboard shows the state of a running daemon full-screen in the
+// terminal: the token, each target, and recent log entries, with keys to
+// renew the token and inspect the token held by a target.
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/codozor/authk/internal/daemon"
+	"golang.org/x/oauth2"
+	"golang.org/x/term"
+)
+
+const (
+	// redrawInterval is how often the countdowns are redrawn
+	redrawInterval = time.Second
+
+	enterScreen = "\x1b[?1049h\x1b[?25l"
+	leaveScreen = "\x1b[?25h\x1b[?1049l"
+)
+
+// Source is the daemon shown. It is implemented by *daemon.Daemon.
+type Source interface {
+	Status() daemon.Status
+	Token() *oauth2.Token
+	Refresh(reauthenticate bool)
+}
+
+// Dashboard is a full-screen view of a daemon.
+type Dashboard struct {
+	source Source
+	events *Events
+	config string
+
+	// selected is the index of the selected target, inspecting shows the
+	// token it holds instead of the events
+	selected   int
+	inspecting bool
+	// notice is the outcome of the last key, shown in the footer
+	notice string
+}
+
+// New returns a dashboard for source, loaded from configPath, listing the
+// log entries in events.
+func New(source Source, events *Events, configPath string) *Dashboard {
+	return &Dashboard{source: source, events: events, config: configPath}
+}
+
+// Run takes over the terminal until q or Ctrl+C is pressed, or ctx is
+// cancelled, and restores it before returning.
+func (db *Dashboard) Run(ctx context.Context) error {
+	in, out := os.Stdin, os.Stdout
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return fmt.Errorf("failed to set up terminal: %w", err)
+	}
+	defer func() { _ = term.Restore(int(in.Fd()), state) }()
+	fmt.Fprint(out, enterScreen)
+	defer fmt.Fprint(out, leaveScreen)
+
+	// The reader is left blocked on stdin once the dashboard closes
+	keys := make(chan string, 16)
+	go readKeys(in, keys)
+
+	ticker := time.NewTicker(redrawInterval)
+	defer ticker.Stop()
+	for {
+		width, height, err := term.GetSize(int(out.Fd()))
+		if err != nil {
+			width, height = 80, 24
+		}
+		fmt.Fprint(out, db.frame(time.Now(), width, height))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case key, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			if db.handleKey(key) {
+				return nil
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// handleKey acts on a key press and reports whether to quit.
+func (db *Dashboard) handleKey(key string) bool {
+	switch key {
+	case "q", "ctrl+c":
+		return true
+	case "r":
+		db.source.Refresh(false)
+		db.notice = "Refresh requested"
+	case "l":
+		db.source.Refresh(true)
+		db.notice = "Re-login requested"
+	case "i", "enter":
+		db.inspecting = !db.inspecting
+	case "esc":
+		db.inspecting = false
+	case "up", "k":
+		db.selected--
+	case "down", "j":
+		db.selected++
+	}
+	return false
+}
+
+// readKeys sends the keys read from r until it fails.
+func readKeys(r io.Reader, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 32)
+	for {
+		n, err := r.Read(buf)
+		for _, key := range parseKeys(buf[:n]) {
+			keys <- key
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parseKeys splits the bytes of a terminal read into key names.
+func parseKeys(b []byte) []string {
+	var keys []string
+	for len(b) > 0 {
+		switch {
+		case len(b) >= 3 && b[0] == 0x1b && b[1] == '[':
+			switch b[2] {
+			case 'A':
+				keys = append(keys, "up")
+			case 'B':
+				keys = append(keys, "down")
+			}
+			b = b[3:]
+			continue
+		case b[0] == 0x1b:
+			keys = append(keys, "esc")
+		case b[0] == 0x03:
+			keys = append(keys, "ctrl+c")
+		case b[0] == '\r' || b[0] == '\n':
+			keys = append(keys, "enter")
+		default:
+			keys = append(keys, string(b[0]))
+		}
+		b = b[1:]
+	}
+	return keys
+}
@@ -0,0 +1,124 @@
+package dashboard
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/daemon"
+	"golang.org/x/oauth2"
+)
+
+// fakeSource is a daemon state recording renewal requests.
+type fakeSource struct {
+	status    daemon.Status
+	token     *oauth2.Token
+	refreshes []bool
+}
+
+func (s *fakeSource) Status() daemon.Status       { return s.status }
+func (s *fakeSource) Token() *oauth2.Token        { return s.token }
+func (s *fakeSource) Refresh(reauthenticate bool) { s.refreshes = append(s.refreshes, reauthenticate) }
+
+func testJWT(claims string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	return header + "." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+}
+
+func TestParseKeys(t *testing.T) {
+	got := parseKeys([]byte("r\x1b[A\x1b[Bq\r\x1b\x03"))
+	want := []string{"r", "up", "down", "q", "enter", "esc", "ctrl+c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseKeys() = %v, want %v", got, want)
+	}
+}
+
+func TestDashboard_HandleKey(t *testing.T) {
+	source := &fakeSource{}
+	db := New(source, NewEvents(10), "authk.cue")
+
+	for _, key := range []string{"r", "l", "down", "i"} {
+		if db.handleKey(key) {
+			t.Fatalf("%s quit the dashboard", key)
+		}
+	}
+	if !reflect.DeepEqual(source.refreshes, []bool{false, true}) {
+		t.Errorf("refreshes = %v, want a refresh then a re-login", source.refreshes)
+	}
+	if db.selected != 1 || !db.inspecting || db.notice != "Re-login requested" {
+		t.Errorf("unexpected state: selected %d, inspecting %v, notice %q", db.selected, db.inspecting, db.notice)
+	}
+	db.handleKey("esc")
+	if db.inspecting {
+		t.Error("expected esc to close the token view")
+	}
+	if !db.handleKey("q") || !db.handleKey("ctrl+c") {
+		t.Error("expected q and Ctrl+C to quit")
+	}
+}
+
+func TestDashboard_Frame(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, ".env")
+	access := testJWT(`{"sub":"service-account","scope":"openid profile","exp":` + strconv.FormatInt(now.Add(time.Hour).Unix(), 10) + `}`)
+	if err := os.WriteFile(envFile, []byte("TOKEN="+access+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	source := &fakeSource{
+		token: &oauth2.Token{AccessToken: access},
+		status: daemon.Status{
+			IssuedAt:    now.Add(-time.Minute),
+			Expiry:      now.Add(time.Hour),
+			NextRefresh: now.Add(59 * time.Minute),
+			Targets: []daemon.TargetStatus{
+				{File: envFile, Key: "TOKEN", UpdatedAt: now.Add(-time.Minute)},
+				{File: filepath.Join(dir, "missing", ".env"), Key: "TOKEN", Error: "no such file or directory"},
+			},
+		},
+	}
+	events := NewEvents(10)
+	_, _ = events.Write([]byte(`{"level":"info","time":"2025-01-01T12:00:00Z","message":"Target updated"}`))
+	db := New(source, events, "/project/authk.cue")
+
+	frame := db.frame(now, 200, 30)
+	for _, want := range []string{
+		"authk  /project/authk.cue",
+		"Subject:       service-account",
+		"Scopes:        openid profile",
+		"Last refresh:  succeeded",
+		"> " + envFile + " (TOKEN)  written 1m0s ago  expires in ",
+		"refresh in 59m0s",
+		"write failed: no such file or directory",
+		"INF Target updated",
+		footer,
+	} {
+		if !strings.Contains(frame, want) {
+			t.Errorf("frame missing %q:\n%s", want, frame)
+		}
+	}
+	if lines := strings.Count(frame, "\r\n") + 1; lines != 30 {
+		t.Errorf("expected the frame to fill 30 lines, got %d", lines)
+	}
+
+	db.handleKey("i")
+	frame = db.frame(now, 200, 30)
+	if !strings.Contains(frame, "Token in "+envFile) || !strings.Contains(frame, `"sub": "service-account"`) {
+		t.Errorf("expected the token of the selected target:\n%s", frame)
+	}
+
+	// Lines are cut to the width of the terminal
+	escapes := regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]`)
+	for _, line := range strings.Split(db.frame(now, 20, 30), "\r\n") {
+		if text := escapes.ReplaceAllString(line, ""); len([]rune(text)) > 20 {
+			t.Errorf("line longer than the terminal: %q", text)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is a log entry shown by the dashboard.
+type Event struct {
+	Time    time.Time
+	Level   string
+	Message string
+	// Fields are the other fields of the entry, as key=value pairs
+	Fields string
+}
+
+// Events keeps the latest log entries, written to it as JSON lines by the
+// logger.
+type Events struct {
+	mu      sync.Mutex
+	size    int
+	entries []Event
+}
+
+// NewEvents returns a buffer keeping the latest size entries.
+func NewEvents(size int) *Events {
+	return &Events{size: size}
+}
+
+// Write records the log entry in p. Entries that cannot be decoded are
+// dropped rather than failing the logger.
+func (e *Events) Write(p []byte) (int, error) {
+	var entry map[string]interface{}
+	if err := json.Unmarshal(p, &entry); err != nil {
+		return len(p), nil
+	}
+
+	event := Event{}
+	if t, ok := entry["time"].(string); ok {
+		event.Time, _ = time.Parse(time.RFC3339, t)
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Level, _ = entry["level"].(string)
+	event.Message, _ = entry["message"].(string)
+	delete(entry, "time")
+	delete(entry, "level")
+	delete(entry, "message")
+	event.Fields = formatFields(entry)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.entries = append(e.entries, event)
+	if len(e.entries) > e.size {
+		e.entries = e.entries[len(e.entries)-e.size:]
+	}
+	return len(p), nil
+}
+
+// Recent returns the latest n entries, oldest first.
+func (e *Events) Recent(n int) []Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if n <= 0 {
+		return nil
+	}
+	if n > len(e.entries) {
+		n = len(e.entries)
+	}
+	return append([]Event(nil), e.entries[len(e.entries)-n:]...)
+}
+
+// formatFields formats fields as key=value pairs, the error first.
+func formatFields(fields map[string]interface{}) string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		if key != "error" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if _, ok := fields["error"]; ok {
+		keys = append([]string{"error"}, keys...)
+	}
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		value, ok := fields[key].(string)
+		if !ok {
+			raw, _ := json.Marshal(fields[key])
+			value = string(raw)
+		}
+		pairs[i] = fmt.Sprintf("%s=%s", key, value)
+	}
+	return strings.Join(pairs, " ")
+}
@@ -0,0 +1,43 @@
+package dashboard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestEvents(t *testing.T) {
+	events := NewEvents(2)
+	logger := zerolog.New(events).With().Timestamp().Logger()
+
+	logger.Info().Msg("First")
+	logger.Warn().Str("file", ".env").Int("attempt", 2).Msg("Second")
+	logger.Error().Err(errTest("connection refused")).Str("reason", "network").Msg("Third")
+	_, _ = events.Write([]byte("not json"))
+
+	recent := events.Recent(10)
+	if len(recent) != 2 {
+		t.Fatalf("expected the 2 latest events, got %+v", recent)
+	}
+	if recent[0].Message != "Second" || recent[0].Level != "warn" || recent[0].Fields != "attempt=2 file=.env" {
+		t.Errorf("unexpected event: %+v", recent[0])
+	}
+	if recent[1].Fields != "error=connection refused reason=network" {
+		t.Errorf("expected the error first, got %q", recent[1].Fields)
+	}
+	if time.Since(recent[1].Time) > time.Minute {
+		t.Errorf("unexpected time: %v", recent[1].Time)
+	}
+
+	if got := events.Recent(1); len(got) != 1 || got[0].Message != "Third" {
+		t.Errorf("Recent(1) = %+v", got)
+	}
+	if got := events.Recent(-1); len(got) != 0 {
+		t.Errorf("Recent(-1) = %+v", got)
+	}
+}
+
+type errTest string
+
+func (e errTest) Error() string { return string(e) }
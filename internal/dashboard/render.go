@@ -0,0 +1,235 @@
+package dashboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/codozor/authk/internal/daemon"
+	"github.com/codozor/authk/internal/display"
+	"github.com/codozor/authk/internal/env"
+	"github.com/codozor/authk/internal/jwtclaims"
+	"github.com/fatih/color"
+)
+
+var (
+	titleStyle   = color.New(color.FgCyan, color.Bold)
+	headingStyle = color.New(color.Bold)
+	okStyle      = color.New(color.FgGreen)
+	warnStyle    = color.New(color.FgYellow)
+	errorStyle   = color.New(color.FgRed)
+	faintStyle   = color.New(color.Faint)
+)
+
+const footer = "[r] refresh  [l] re-login  [i] inspect  [↑/↓] select  [q] quit"
+
+// line is a line of the screen, styled as a whole once cut to the width of
+// the terminal.
+type line struct {
+	text  string
+	style *color.Color
+}
+
+// frame draws the screen over the previous one.
+func (db *Dashboard) frame(now time.Time, width, height int) string {
+	// Keep the footer on the last line
+	body := max(height-1, 0)
+	lines := db.lines(now, body)
+	if len(lines) > body {
+		lines = lines[:body]
+	}
+	for len(lines) < body {
+		lines = append(lines, line{})
+	}
+	status := footer
+	if db.notice != "" {
+		status += "   " + db.notice
+	}
+	lines = append(lines, line{text: status, style: faintStyle})
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, l := range lines {
+		text := truncate(l.text, width)
+		if l.style != nil {
+			text = l.style.Sprint(text)
+		}
+		b.WriteString(text)
+		b.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString("\x1b[J")
+	return b.String()
+}
+
+// lines returns the content of the screen, without the footer, listing as
+// many recent events as fit in height lines.
+func (db *Dashboard) lines(now time.Time, height int) []line {
+	status := db.source.Status()
+	token := db.source.Token()
+	lines := []line{
+		{text: fmt.Sprintf("authk  %s  %s", db.config, now.Local().Format(time.TimeOnly)), style: titleStyle},
+		{},
+	}
+
+	if token == nil || !status.HasToken() {
+		lines = append(lines, line{text: "Waiting for the first token", style: warnStyle})
+	} else {
+		claims := jwtclaims.Parse(token.AccessToken)
+		lines = append(lines,
+			line{text: "Subject:       " + display.OrDash(claims.String("sub"))},
+			line{text: "Scopes:        " + display.OrDash(strings.Join(jwtclaims.Scopes(token, claims), " "))},
+		)
+		if !status.Expiry.IsZero() {
+			l := line{text: "Expires:       " + display.Relative(status.Expiry, now)}
+			if !status.Expiry.After(now) {
+				l.style = errorStyle
+			}
+			lines = append(lines, l)
+		}
+	}
+	if !status.NextRefresh.IsZero() {
+		lines = append(lines, line{text: "Next refresh:  " + display.Relative(status.NextRefresh, now)})
+	}
+	switch {
+	case status.LastError != "":
+		lines = append(lines, line{
+			text:  fmt.Sprintf("Last refresh:  failed %s (attempt %d): %s", display.Relative(status.LastErrorAt, now), status.Attempt, status.LastError),
+			style: errorStyle,
+		})
+	case status.HasToken():
+		lines = append(lines, line{text: "Last refresh:  succeeded " + display.Relative(status.IssuedAt, now), style: okStyle})
+	}
+
+	lines = append(lines, line{}, line{text: "Targets", style: headingStyle})
+	db.selected = min(max(db.selected, 0), max(len(status.Targets)-1, 0))
+	for i, target := range status.Targets {
+		marker := "  "
+		if i == db.selected {
+			marker = "> "
+		}
+		lines = append(lines, targetLine(marker, target, status.NextRefresh, now))
+	}
+	if len(status.Targets) == 0 {
+		lines = append(lines, line{text: "  none"})
+	}
+
+	lines = append(lines, line{})
+	if db.inspecting && len(status.Targets) > 0 {
+		return append(lines, inspectLines(status.Targets[db.selected])...)
+	}
+	lines = append(lines, line{text: "Recent events", style: headingStyle})
+	for _, event := range db.events.Recent(height - len(lines)) {
+		lines = append(lines, eventLine(event))
+	}
+	return lines
+}
+
+// targetLine describes a target and the token it holds.
+func targetLine(marker string, target daemon.TargetStatus, nextRefresh, now time.Time) line {
+	text := fmt.Sprintf("%s%s (%s)  ", marker, target.File, target.Key)
+	if target.Error != "" {
+		return line{text: text + "write failed: " + target.Error, style: errorStyle}
+	}
+
+	value, err := env.NewManager(target.File, target.Key).Get()
+	if err != nil || value == "" {
+		return line{text: text + "no token in file", style: warnStyle}
+	}
+	var details []string
+	if !target.UpdatedAt.IsZero() {
+		details = append(details, "written "+display.Ago(target.UpdatedAt, now))
+	}
+	style := okStyle
+	if expiry := jwtclaims.Parse(value).Time("exp"); !expiry.IsZero() {
+		if expiry.After(now) {
+			details = append(details, "expires in "+expiry.Sub(now).Round(time.Second).String())
+		} else {
+			details = append(details, "expired "+display.Ago(expiry, now))
+			style = errorStyle
+		}
+	}
+	if !nextRefresh.IsZero() {
+		details = append(details, "refresh in "+max(nextRefresh.Sub(now), 0).Round(time.Second).String())
+	}
+	return line{text: text + strings.Join(details, "  "), style: style}
+}
+
+// inspectLines shows the decoded header and claims of the token in target.
+func inspectLines(target daemon.TargetStatus) []line {
+	lines := []line{{text: fmt.Sprintf("Token in %s (%s)  [esc] back", target.File, target.Key), style: headingStyle}}
+	value, err := env.NewManager(target.File, target.Key).Get()
+	if err != nil || value == "" {
+		return append(lines, line{text: "No token in file", style: warnStyle})
+	}
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 {
+		return append(lines, line{text: "Not a JWT, the token is opaque"})
+	}
+	for i, name := range []string{"Header", "Claims"} {
+		lines = append(lines, line{text: name + ":", style: faintStyle})
+		payload, err := jwtclaims.Segment(parts[i])
+		var pretty bytes.Buffer
+		if err == nil {
+			err = json.Indent(&pretty, payload, "", "  ")
+		}
+		if err != nil {
+			lines = append(lines, line{text: "  cannot be decoded", style: errorStyle})
+			continue
+		}
+		for _, text := range strings.Split(pretty.String(), "\n") {
+			lines = append(lines, line{text: "  " + text})
+		}
+	}
+	return lines
+}
+
+func eventLine(event Event) line {
+	text := fmt.Sprintf("%s %s %s", event.Time.Local().Format(time.TimeOnly), levelLabel(event.Level), event.Message)
+	if event.Fields != "" {
+		text += " " + event.Fields
+	}
+	switch event.Level {
+	case "error", "fatal", "panic":
+		return line{text: text, style: errorStyle}
+	case "warn":
+		return line{text: text, style: warnStyle}
+	case "debug", "trace":
+		return line{text: text, style: faintStyle}
+	}
+	return line{text: text}
+}
+
+func levelLabel(level string) string {
+	switch level {
+	case "trace":
+		return "TRC"
+	case "debug":
+		return "DBG"
+	case "info":
+		return "INF"
+	case "warn":
+		return "WRN"
+	case "error":
+		return "ERR"
+	case "fatal":
+		return "FTL"
+	}
+	return "???"
+}
+
+// truncate cuts s to width runes.
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if width <= 0 || len(runes) <= width {
+		return s
+	}
+	if width == 1 {
+		return "…"
+	}
+	return string(runes[:width-1]) + "…"
+}
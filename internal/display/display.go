@@ -0,0 +1,34 @@
+// Package display formats values for the human-readable output of authk
+// commands and the dashboard.
+package display
+
+import (
+	"fmt"
+	"time"
+)
+
+// Relative formats t with its distance from now, such as
+// "15:04:05 (in 4m0s)". The date is only shown more than a day away.
+func Relative(t, now time.Time) string {
+	stamp := t.Local().Format(time.TimeOnly)
+	if t.Sub(now) > 24*time.Hour || now.Sub(t) > 24*time.Hour {
+		stamp = t.Local().Format(time.DateTime)
+	}
+	if d := t.Sub(now).Round(time.Second); d >= 0 {
+		return fmt.Sprintf("%s (in %s)", stamp, d)
+	}
+	return fmt.Sprintf("%s (%s)", stamp, Ago(t, now))
+}
+
+// Ago formats how long before now t was, such as "4m0s ago".
+func Ago(t, now time.Time) string {
+	return now.Sub(t).Round(time.Second).String() + " ago"
+}
+
+// OrDash returns s, or "-" when it is empty, to keep table columns aligned.
+func OrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
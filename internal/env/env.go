@@ -2,11 +2,13 @@ package env
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"syscall"
 )
 
 type Manager struct {
@@ -30,14 +32,7 @@ func (m *Manager) Update(value string) error {
 	found := false
 	newLines := make([]string, 0, len(lines)+1)
 
-	// Regex to match:
-	// Group 1: Leading whitespace
-	// Group 2: Optional "export "
-	// Group 3: Key
-	// Group 4: Equals sign with optional surrounding whitespace
-	// Group 5: The rest of the line (value + comment)
-	regexStr := fmt.Sprintf(`^(\s*)(export\s+)?(%s)(\s*=\s*)(.*)$`, regexp.QuoteMeta(m.key))
-	re := regexp.MustCompile(regexStr)
+	re := m.keyRegexp()
 
 	for _, line := range lines {
 		matches := re.FindStringSubmatch(line)
@@ -73,14 +68,37 @@ func (m *Manager) Update(value string) error {
 	return m.writeLines(newLines)
 }
 
+// Remove deletes every line defining the key. A missing file is not an error.
+func (m *Manager) Remove() error {
+	lines, err := m.readLines()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read .env file: %w", err)
+	}
+
+	re := m.keyRegexp()
+	newLines := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if !re.MatchString(line) {
+			newLines = append(newLines, line)
+		}
+	}
+	if len(newLines) == len(lines) {
+		return nil
+	}
+
+	return m.writeLines(newLines)
+}
+
 func (m *Manager) Get() (string, error) {
 	lines, err := m.readLines()
 	if err != nil {
 		return "", err
 	}
 
-	regexStr := fmt.Sprintf(`^(\s*)(export\s+)?(%s)(\s*=\s*)(.*)$`, regexp.QuoteMeta(m.key))
-	re := regexp.MustCompile(regexStr)
+	re := m.keyRegexp()
 
 	for _, line := range lines {
 		matches := re.FindStringSubmatch(line)
@@ -107,6 +125,18 @@ func (m *Manager) Get() (string, error) {
 	return "", fmt.Errorf("key %s not found in .env file", m.key)
 }
 
+// keyRegexp matches a line defining the key.
+//
+// Group 1: Leading whitespace
+// Group 2: Optional "export "
+// Group 3: Key
+// Group 4: Equals sign with optional surrounding whitespace
+// Group 5: The rest of the line (value + comment)
+func (m *Manager) keyRegexp() *regexp.Regexp {
+	regexStr := fmt.Sprintf(`^(\s*)(export\s+)?(%s)(\s*=\s*)(.*)$`, regexp.QuoteMeta(m.key))
+	return regexp.MustCompile(regexStr)
+}
+
 func (m *Manager) readLines() ([]string, error) {
 	file, err := os.Open(m.filePath)
 	if err != nil {
@@ -122,13 +152,66 @@ func (m *Manager) readLines() ([]string, error) {
 	return lines, scanner.Err()
 }
 
+// writeLines replaces the file atomically, so that readers and an interrupted
+// authk never observe a partially written file. A symlinked file is replaced
+// at its destination, so that the link itself is kept. A file that cannot be
+// replaced, such as one bind-mounted on its own into a container, is
+// rewritten in place instead.
 func (m *Manager) writeLines(lines []string) error {
-	file, err := os.Create(m.filePath)
+	path := m.filePath
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return fmt.Errorf("failed to create/open .env file: %w", err)
 	}
-	defer file.Close()
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath) // No-op once renamed
+
+	if err := writeAll(file, lines); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Chmod(mode); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		// Bind mounts of a single file refuse renames onto them
+		if errors.Is(err, syscall.EXDEV) || errors.Is(err, syscall.EBUSY) {
+			return writeInPlace(path, lines)
+		}
+		return err
+	}
+	return nil
+}
+
+// writeInPlace truncates and rewrites the file, keeping its inode. Readers
+// may observe it partially written.
+func writeInPlace(path string, lines []string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open .env file: %w", err)
+	}
+	if err := writeAll(file, lines); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
 
+func writeAll(file *os.File, lines []string) error {
 	writer := bufio.NewWriter(file)
 	for _, line := range lines {
 		if _, err := writer.WriteString(line + "\n"); err != nil {
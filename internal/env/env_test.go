@@ -178,3 +178,100 @@ func TestFind_WithSeparator(t *testing.T) {
 		t.Errorf("Find() = %s, want %s", found, path)
 	}
 }
+
+func TestManager_Remove(t *testing.T) {
+	tmpDir := t.TempDir()
+	envFile := filepath.Join(tmpDir, ".env")
+	if err := os.WriteFile(envFile, []byte("OTHER=foo\nexport KEY=\"old\"\nANOTHER=bar\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(envFile, "KEY")
+	if err := m.Remove(); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+
+	content, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "OTHER=foo\nANOTHER=bar\n"; string(content) != want {
+		t.Errorf("Remove() result mismatch:\ngot:\n%s\nwant:\n%s", string(content), want)
+	}
+
+	// Permissions survive the atomic rewrite
+	info, err := os.Stat(envFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %o", info.Mode().Perm())
+	}
+
+	missing := NewManager(filepath.Join(tmpDir, "missing.env"), "KEY")
+	if err := missing.Remove(); err != nil {
+		t.Errorf("Remove() on missing file error = %v", err)
+	}
+}
+
+func TestManager_Update_Symlink(t *testing.T) {
+	tmpDir := t.TempDir()
+	target := filepath.Join(tmpDir, "shared.env")
+	if err := os.WriteFile(target, []byte("OTHER=foo\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(tmpDir, ".env")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	if err := NewManager(link, "KEY").Update("value"); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Error("Update() replaced the symlink with a regular file")
+	}
+	content, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "OTHER=foo\nKEY=\"value\"\n"; string(content) != want {
+		t.Errorf("Update() result mismatch:\ngot:\n%s\nwant:\n%s", string(content), want)
+	}
+}
+
+func TestWriteInPlace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("OTHER=foo\nKEY=\"a much longer previous value\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeInPlace(path, []string{"OTHER=foo", `KEY="value"`}); err != nil {
+		t.Fatalf("writeInPlace() error = %v", err)
+	}
+
+	// A bind mount only follows the file it was made on
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("writeInPlace() replaced the file")
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "OTHER=foo\nKEY=\"value\"\n"; string(content) != want {
+		t.Errorf("writeInPlace() result mismatch:\ngot:\n%s\nwant:\n%s", string(content), want)
+	}
+}
@@ -0,0 +1,289 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"github.com/rs/zerolog/log"
+)
+
+// Ways a command receives the token.
+const (
+	TokenEnv   = "env"
+	TokenStdin = "stdin"
+	TokenNone  = "none"
+)
+
+// EnvToken is the environment variable holding the token for commands.
+const EnvToken = "AUTHK_TOKEN"
+
+// defaultTimeout matches the schema default.
+const defaultTimeout = 30 * time.Second
+
+// maxOutput caps the command output kept for logs.
+const maxOutput = 4096
+
+// waitDelay bounds how long a command that exited or timed out is waited for
+// while a process it started in the background still holds its output open.
+const waitDelay = time.Second
+
+// Hook is a validated onUpdate action.
+type Hook struct {
+	cfg     config.Hook
+	signal  os.Signal
+	timeout time.Duration
+}
+
+// Event describes the update a hook runs for.
+type Event struct {
+	Token string
+	// Target is the target that was written, nil for config-wide hooks
+	Target *config.Target
+}
+
+// New validates a hook from the config.
+func New(cfg config.Hook) (Hook, error) {
+	h := Hook{cfg: cfg, timeout: defaultTimeout}
+
+	actions := 0
+	if len(cfg.Command) > 0 {
+		actions++
+	}
+	if cfg.Signal != "" {
+		actions++
+	}
+	if cfg.Touch != "" {
+		actions++
+	}
+	if actions != 1 {
+		return Hook{}, errors.New("hook needs exactly one of command, signal or touch")
+	}
+
+	switch cfg.Token {
+	case "", TokenEnv, TokenStdin, TokenNone:
+	default:
+		return Hook{}, fmt.Errorf("unsupported hook token %q", cfg.Token)
+	}
+
+	if cfg.Signal != "" {
+		sig, err := ParseSignal(cfg.Signal)
+		if err != nil {
+			return Hook{}, err
+		}
+		if (cfg.PID == 0) == (cfg.PIDFile == "") {
+			return Hook{}, errors.New("signal hook needs exactly one of pid or pidFile")
+		}
+		h.signal = sig
+	}
+
+	if cfg.Timeout != "" {
+		timeout, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return Hook{}, fmt.Errorf("invalid hook timeout: %w", err)
+		}
+		h.timeout = timeout
+	}
+
+	return h, nil
+}
+
+// String describes the hook for logs, without its arguments.
+func (h Hook) String() string {
+	switch {
+	case len(h.cfg.Command) > 0:
+		return "command " + h.cfg.Command[0]
+	case h.cfg.Signal != "":
+		if h.cfg.PIDFile != "" {
+			return fmt.Sprintf("signal %s to %s", h.cfg.Signal, h.cfg.PIDFile)
+		}
+		return fmt.Sprintf("signal %s to %d", h.cfg.Signal, h.cfg.PID)
+	default:
+		return "touch " + h.cfg.Touch
+	}
+}
+
+// Run runs the hook for ev, giving up after the hook timeout.
+func (h Hook) Run(ctx context.Context, ev Event) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	switch {
+	case len(h.cfg.Command) > 0:
+		return h.runCommand(ctx, ev)
+	case h.cfg.Signal != "":
+		return h.sendSignal()
+	default:
+		return touch(h.cfg.Touch)
+	}
+}
+
+func (h Hook) runCommand(ctx context.Context, ev Event) error {
+	cmd := exec.CommandContext(ctx, h.cfg.Command[0], h.cfg.Command[1:]...)
+	cmd.Env = os.Environ()
+	if ev.Target != nil {
+		cmd.Env = append(cmd.Env, "AUTHK_TARGET_FILE="+ev.Target.File, "AUTHK_TARGET_KEY="+ev.Target.Key)
+	}
+	switch h.cfg.Token {
+	case TokenStdin:
+		cmd.Stdin = strings.NewReader(ev.Token)
+	case TokenNone:
+	default:
+		cmd.Env = append(cmd.Env, EnvToken+"="+ev.Token)
+	}
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.WaitDelay = waitDelay
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", h.timeout)
+	}
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The command succeeded, only a process it left running in the
+		// background kept its output open
+		err = nil
+	}
+	if err != nil {
+		out := output.String()
+		if len(out) > maxOutput {
+			out = out[:maxOutput]
+		}
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(out))
+	}
+	return nil
+}
+
+func (h Hook) sendSignal() error {
+	pid := h.cfg.PID
+	if h.cfg.PIDFile != "" {
+		content, err := os.ReadFile(h.cfg.PIDFile)
+		if err != nil {
+			return fmt.Errorf("failed to read pid file: %w", err)
+		}
+		pid, err = strconv.Atoi(strings.TrimSpace(string(content)))
+		if err != nil {
+			return fmt.Errorf("invalid pid file %s: %w", h.cfg.PIDFile, err)
+		}
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("failed to find process %d: %w", pid, err)
+	}
+	if err := process.Signal(h.signal); err != nil {
+		return fmt.Errorf("failed to signal process %d: %w", pid, err)
+	}
+	return nil
+}
+
+func touch(path string) error {
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// Set holds the hooks of a config: those of each target and config-wide ones.
+// A nil Set runs nothing.
+type Set struct {
+	global  []Hook
+	targets []targetHooks
+}
+
+type targetHooks struct {
+	target config.Target
+	hooks  []Hook
+}
+
+// NewSet validates the hooks of cfg.
+func NewSet(cfg *config.Config) (*Set, error) {
+	set := &Set{}
+	for i, hookCfg := range cfg.OnUpdate {
+		h, err := New(hookCfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid onUpdate[%d]: %w", i, err)
+		}
+		set.global = append(set.global, h)
+	}
+	for _, target := range cfg.Targets {
+		th := targetHooks{target: target}
+		for i, hookCfg := range target.OnUpdate {
+			h, err := New(hookCfg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid onUpdate[%d] of target %s: %w", i, target.File, err)
+			}
+			th.hooks = append(th.hooks, h)
+		}
+		if len(th.hooks) > 0 {
+			set.targets = append(set.targets, th)
+		}
+	}
+	return set, nil
+}
+
+// TargetUpdated runs the hooks of target after token was written to it.
+func (s *Set) TargetUpdated(ctx context.Context, target config.Target, token string) {
+	if s == nil {
+		return
+	}
+	for _, th := range s.targets {
+		if th.target.Same(target) {
+			runAll(ctx, th.hooks, Event{Token: token, Target: &target})
+		}
+	}
+}
+
+// Updated runs the config-wide hooks after token was written.
+func (s *Set) Updated(ctx context.Context, token string) {
+	if s == nil {
+		return
+	}
+	runAll(ctx, s.global, Event{Token: token})
+}
+
+// runAll runs hooks in order and logs their outcome. A failing hook does not
+// prevent the next ones from running.
+func runAll(ctx context.Context, hooks []Hook, ev Event) {
+	for _, h := range hooks {
+		start := time.Now()
+		err := h.Run(ctx, ev)
+		event := log.Info()
+		if err != nil {
+			event = log.Error().Err(err)
+		}
+		if ev.Target != nil {
+			event = event.Str("file", ev.Target.File)
+		}
+		event = event.Str("hook", h.String()).Dur("duration", time.Since(start))
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			event = event.Int("exit_code", exitErr.ExitCode())
+		} else if err == nil && len(h.cfg.Command) > 0 {
+			event = event.Int("exit_code", 0)
+		}
+
+		if err != nil {
+			event.Msg("Hook failed")
+		} else {
+			event.Msg("Hook succeeded")
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package hooks
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Hook
+		wantErr bool
+	}{
+		{name: "Command", cfg: config.Hook{Command: []string{"true"}, Token: TokenStdin}},
+		{name: "Touch", cfg: config.Hook{Touch: "/tmp/reload", Timeout: "5s"}},
+		{name: "Signal", cfg: config.Hook{Signal: "KILL", PIDFile: "/run/app.pid"}},
+		{name: "No action", cfg: config.Hook{}, wantErr: true},
+		{name: "Two actions", cfg: config.Hook{Command: []string{"true"}, Touch: "/tmp/reload"}, wantErr: true},
+		{name: "Signal without target", cfg: config.Hook{Signal: "KILL"}, wantErr: true},
+		{name: "Signal with two targets", cfg: config.Hook{Signal: "KILL", PID: 1, PIDFile: "/run/app.pid"}, wantErr: true},
+		{name: "Unknown signal", cfg: config.Hook{Signal: "NOPE", PID: 1}, wantErr: true},
+		{name: "Invalid timeout", cfg: config.Hook{Touch: "/tmp/reload", Timeout: "soon"}, wantErr: true},
+		{name: "Invalid token", cfg: config.Hook{Command: []string{"true"}, Token: "argv"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	dir := t.TempDir()
+	first := config.Target{File: filepath.Join(dir, ".env.1"), Key: "TOKEN"}
+	second := config.Target{File: filepath.Join(dir, ".env.2"), Key: "TOKEN"}
+	first.OnUpdate = []config.Hook{{Touch: filepath.Join(dir, "first")}}
+
+	set, err := NewSet(&config.Config{
+		Targets:  []config.Target{first, second},
+		OnUpdate: []config.Hook{{Touch: filepath.Join(dir, "global")}},
+	})
+	if err != nil {
+		t.Fatalf("NewSet() error = %v", err)
+	}
+
+	set.TargetUpdated(context.Background(), second, "token")
+	if _, err := os.Stat(filepath.Join(dir, "first")); err == nil {
+		t.Error("hook of the first target ran for the second")
+	}
+
+	set.TargetUpdated(context.Background(), config.Target{File: first.File, Key: first.Key}, "token")
+	if _, err := os.Stat(filepath.Join(dir, "first")); err != nil {
+		t.Errorf("hook of the first target did not run: %v", err)
+	}
+
+	set.Updated(context.Background(), "token")
+	if _, err := os.Stat(filepath.Join(dir, "global")); err != nil {
+		t.Errorf("config-wide hook did not run: %v", err)
+	}
+
+	if _, err := NewSet(&config.Config{OnUpdate: []config.Hook{{}}}); err == nil {
+		t.Error("NewSet() expected error for an invalid hook, got nil")
+	}
+
+	// A nil set runs nothing
+	var none *Set
+	none.Updated(context.Background(), "token")
+}
+
+func TestTouch_UpdatesModTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reload")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := New(config.Hook{Touch: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Run(context.Background(), Event{}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().After(old.Add(time.Minute)) {
+		t.Errorf("modification time not updated: %s", info.ModTime())
+	}
+}
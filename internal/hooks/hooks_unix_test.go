@@ -0,0 +1,130 @@
+//go:build !windows
+
+package hooks
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+)
+
+func TestHook_Command(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "Env", token: TokenEnv},
+		{name: "Stdin", token: TokenStdin},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "out")
+			script := `printf "%s" "$AUTHK_TOKEN" > "$0"; cat >> "$0"; printf " %s" "$AUTHK_TARGET_KEY" >> "$0"`
+			h, err := New(config.Hook{Command: []string{"sh", "-c", script, out}, Token: tt.token})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			target := config.Target{File: ".env", Key: "TOKEN"}
+			if err := h.Run(context.Background(), Event{Token: "secret", Target: &target}); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			content, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != "secret TOKEN" {
+				t.Errorf("command saw %q, want %q", content, "secret TOKEN")
+			}
+		})
+	}
+}
+
+func TestHook_CommandFailure(t *testing.T) {
+	h, err := New(config.Hook{Command: []string{"sh", "-c", "echo broken >&2; exit 3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.Run(context.Background(), Event{})
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Run() error = %v, want exit status and output", err)
+	}
+
+	h, err = New(config.Hook{Command: []string{"sleep", "10"}, Timeout: "50ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Run(context.Background(), Event{}); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Run() error = %v, want timeout", err)
+	}
+}
+
+func TestHook_CommandBackground(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		timeout string
+		wantErr string
+	}{
+		{name: "Exited", script: "sleep 10 & echo started", timeout: "5s"},
+		{name: "Timed out", script: "sleep 10 & sleep 10", timeout: "50ms", wantErr: "timed out"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := New(config.Hook{Command: []string{"sh", "-c", tt.script}, Timeout: tt.timeout})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The background sleep holds the output open, it must not keep
+			// the hook from returning
+			start := time.Now()
+			err = h.Run(context.Background(), Event{})
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Run() took %s", elapsed)
+			}
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Run() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Run() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHook_Signal(t *testing.T) {
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, syscall.SIGUSR1)
+	defer signal.Stop(received)
+
+	pidFile := filepath.Join(t.TempDir(), "app.pid")
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := New(config.Hook{Signal: "SIGUSR1", PIDFile: pidFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Run(context.Background(), Event{}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal not received")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package hooks
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ParseSignal only accepts KILL on Windows, which cannot deliver other
+// signals to processes.
+func ParseSignal(name string) (os.Signal, error) {
+	if strings.TrimPrefix(strings.ToUpper(name), "SIG") == "KILL" {
+		return os.Kill, nil
+	}
+	return nil, fmt.Errorf("signal %q is not supported on Windows, only KILL is", name)
+}
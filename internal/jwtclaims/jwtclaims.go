@@ -0,0 +1,79 @@
+// Package jwtclaims reads the claims of JWTs without verifying them. authk
+// only uses them to describe tokens: access tokens are meant for resource
+// servers, which check their signature.
+package jwtclaims
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Claims are the decoded claims of a JWT. A nil Claims, as returned for
+// opaque tokens, has no claims.
+type Claims map[string]interface{}
+
+// Parse decodes the claims of token, or returns nil when it is not a JWT.
+func Parse(token string) Claims {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := Segment(parts[1])
+	if err != nil {
+		return nil
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+	return claims
+}
+
+// Segment decodes a base64url segment of a JWT, with or without padding.
+func Segment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
+
+// String returns the string claim name, or "" when it is missing.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Time returns the NumericDate claim name, such as exp or iat, or the zero
+// time when it is missing.
+func (c Claims) Time(name string) time.Time {
+	seconds, ok := c[name].(float64)
+	if !ok || seconds <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(seconds), 0)
+}
+
+// Scopes returns the scopes granted with token, from the token response,
+// which may differ from those requested, or else from the scope claim or the
+// scp claim used by Entra ID and Okta.
+func Scopes(token *oauth2.Token, claims Claims) []string {
+	if scope, ok := token.Extra("scope").(string); ok && scope != "" {
+		return strings.Fields(scope)
+	}
+	for _, name := range []string{"scope", "scp"} {
+		switch scope := claims[name].(type) {
+		case string:
+			return strings.Fields(scope)
+		case []interface{}:
+			var list []string
+			for _, v := range scope {
+				if s, ok := v.(string); ok {
+					list = append(list, s)
+				}
+			}
+			return list
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package jwtclaims
+
+import (
+	"encoding/base64"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestParse(t *testing.T) {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"service-account","exp":1735732800}`))
+	claims := Parse("eyJhbGciOiJub25lIn0." + payload + ".sig")
+	if subject := claims.String("sub"); subject != "service-account" {
+		t.Errorf("subject = %q, want service-account", subject)
+	}
+	if expiry := claims.Time("exp"); !expiry.Equal(time.Unix(1735732800, 0)) {
+		t.Errorf("expiry = %v", expiry)
+	}
+	if iat := claims.Time("iat"); !iat.IsZero() {
+		t.Errorf("missing iat = %v, want zero", iat)
+	}
+
+	// Padded segments, as some IdPs send, are accepted too
+	padded := base64.URLEncoding.EncodeToString([]byte(`{"sub":"padded"}`))
+	if subject := Parse("e30." + padded + ".sig").String("sub"); subject != "padded" {
+		t.Errorf("subject of padded token = %q, want padded", subject)
+	}
+
+	opaque := Parse("opaque-token")
+	if opaque != nil || opaque.String("sub") != "" || !opaque.Time("exp").IsZero() {
+		t.Errorf("Parse() for an opaque token = %v", opaque)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  *oauth2.Token
+		claims Claims
+		want   []string
+	}{
+		{
+			name:   "Token response",
+			token:  (&oauth2.Token{}).WithExtra(map[string]interface{}{"scope": "openid profile"}),
+			claims: Claims{"scope": "ignored"},
+			want:   []string{"openid", "profile"},
+		},
+		{name: "Scope claim", token: &oauth2.Token{}, claims: Claims{"scope": "openid email"}, want: []string{"openid", "email"}},
+		{name: "Scp list", token: &oauth2.Token{}, claims: Claims{"scp": []interface{}{"read", "write"}}, want: []string{"read", "write"}},
+		{name: "None", token: &oauth2.Token{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Scopes(tt.token, tt.claims); !slices.Equal(got, tt.want) {
+				t.Errorf("Scopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
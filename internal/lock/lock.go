@@ -0,0 +1,316 @@
+// Package lock keeps a single authk instance maintaining a config and each
+// of its target files.
+package lock
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/codozor/authk/internal/rundir"
+)
+
+// ErrLocked is returned by Acquire when another instance holds the lock.
+var ErrLocked = errors.New("already locked")
+
+// Owner describes the instance holding a lock. It is stored in the lock
+// file so that other instances can report who they conflict with.
+type Owner struct {
+	PID     int      `json:"pid"`
+	Config  string   `json:"config"`
+	Targets []string `json:"targets"`
+	// Dir is the working directory of the instance
+	Dir       string    `json:"dir"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// Lock is an exclusive lock on a config and each file it maintains, held
+// until Release.
+type Lock struct {
+	file  *os.File
+	path  string
+	owner Owner
+	// targets holds the lock file of every resolved target file
+	targets map[string]*os.File
+}
+
+// Instance is a running authk instance found through its lock file.
+type Instance struct {
+	Owner
+	// Path is the lock file of the instance
+	Path string `json:"lockFile"`
+}
+
+// LockedError reports the instance already holding a lock.
+type LockedError struct {
+	Path string
+	// Target is the file both instances maintain, empty when they share the
+	// config
+	Target string
+	// Owner is nil if the lock file could not be read
+	Owner *Owner
+}
+
+func (e *LockedError) Error() string {
+	if e.Owner == nil {
+		return fmt.Sprintf("another authk instance holds %s", e.Path)
+	}
+	what := "these targets"
+	if e.Target != "" {
+		what = e.Target
+	}
+	return fmt.Sprintf("another authk instance (pid %d, started %s) is already maintaining %s for %s",
+		e.Owner.PID, e.Owner.StartedAt.Format(time.DateTime), what, e.Owner.Config)
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// Path returns the lock file for a config. Paths are resolved, so the same
+// project started from different directories gets the same lock.
+func Path(configPath string) string {
+	return filepath.Join(rundir.Dir(), "lock-"+key(resolve(configPath))+".lock")
+}
+
+// targetPath returns the lock file for a resolved target file.
+func targetPath(file string) string {
+	return filepath.Join(rundir.Dir(), "target-"+key(file)+".lock")
+}
+
+func key(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Acquire takes the lock for a config and for each file it maintains, so
+// that no two instances write the same file, whatever their config or other
+// targets. It fails with a *LockedError when another instance holds one of
+// them.
+func Acquire(configPath string, files []string) (*Lock, error) {
+	path := Path(configPath)
+	if err := rundir.Ensure(filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	f, err := lockPath(path, "")
+	if err != nil {
+		return nil, err
+	}
+
+	dir, _ := os.Getwd()
+	l := &Lock{
+		file: f,
+		path: path,
+		owner: Owner{
+			Dir:       dir,
+			PID:       os.Getpid(),
+			Config:    resolve(configPath),
+			StartedAt: time.Now(),
+		},
+		targets: make(map[string]*os.File),
+	}
+	if err := l.Retarget(files); err != nil {
+		l.Release()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Retarget moves the lock to files, after a config reload changed them.
+// Files no longer maintained are released. When another instance holds one
+// of the new files, it fails with a *LockedError and keeps the current
+// files.
+func (l *Lock) Retarget(files []string) error {
+	resolved := resolveAll(files)
+
+	acquired := make(map[string]*os.File)
+	for _, file := range resolved {
+		if _, ok := l.targets[file]; ok {
+			continue
+		}
+		f, err := lockPath(targetPath(file), file)
+		if err != nil {
+			for _, f := range acquired {
+				releaseFile(f)
+			}
+			return err
+		}
+		acquired[file] = f
+	}
+
+	for file, f := range l.targets {
+		if !slices.Contains(resolved, file) {
+			releaseFile(f)
+			delete(l.targets, file)
+		}
+	}
+	maps.Copy(l.targets, acquired)
+
+	l.owner.Targets = resolved
+	data, err := json.Marshal(l.owner)
+	if err == nil {
+		err = writeOwner(l.file, data)
+	}
+	for _, f := range l.targets {
+		if err == nil {
+			err = writeOwner(f, data)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return nil
+}
+
+// lockPath opens and locks the lock file at path, guarding target when it
+// is not empty.
+func lockPath(path, target string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if errors.Is(err, ErrLocked) {
+			owner, _ := ReadOwner(path)
+			return nil, &LockedError{Path: path, Target: target, Owner: owner}
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// StatePath returns where the instance holding the lock publishes its state.
+func (l *Lock) StatePath() string {
+	return statePath(l.path)
+}
+
+// StatePath returns where the instance publishes its state.
+func (i Instance) StatePath() string {
+	return statePath(i.Path)
+}
+
+func statePath(lockPath string) string {
+	return strings.TrimSuffix(lockPath, ".lock") + ".state.json"
+}
+
+// Find returns the running instances maintaining targets for the config at
+// configPath. Lock files left by instances that exited are ignored.
+func Find(configPath string) ([]Instance, error) {
+	paths, err := filepath.Glob(filepath.Join(rundir.Dir(), "lock-*.lock"))
+	if err != nil {
+		return nil, err
+	}
+
+	config := resolve(configPath)
+	var instances []Instance
+	for _, path := range paths {
+		owner, err := ReadOwner(path)
+		if err != nil || owner.Config != config {
+			continue
+		}
+		held, err := isHeld(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", path, err)
+		}
+		if held {
+			instances = append(instances, Instance{Owner: *owner, Path: path})
+		}
+	}
+	return instances, nil
+}
+
+// isHeld reports whether another process holds the lock on path, by briefly
+// taking a shared lock.
+func isHeld(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	err = probeFile(f)
+	if errors.Is(err, ErrLocked) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return false, unlockFile(f)
+}
+
+// Release gives up the lock. The files are emptied but kept: removing them
+// would let an instance waiting on an old file and one creating a new file
+// both succeed.
+func (l *Lock) Release() error {
+	var errs []error
+	for file, f := range l.targets {
+		errs = append(errs, releaseFile(f))
+		delete(l.targets, file)
+	}
+	errs = append(errs, releaseFile(l.file))
+	return errors.Join(errs...)
+}
+
+func releaseFile(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to clear lock file: %w", err)
+	}
+	if err := unlockFile(f); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to unlock: %w", err)
+	}
+	return f.Close()
+}
+
+// ReadOwner reads the owner recorded in a lock file.
+func ReadOwner(path string) (*Owner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var owner Owner
+	if err := json.Unmarshal(data, &owner); err != nil {
+		return nil, fmt.Errorf("failed to parse lock file: %w", err)
+	}
+	return &owner, nil
+}
+
+func writeOwner(f *os.File, data []byte) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.WriteAt(data, 0)
+	return err
+}
+
+func resolveAll(files []string) []string {
+	resolved := make([]string, len(files))
+	for i, file := range files {
+		resolved[i] = resolve(file)
+	}
+	slices.Sort(resolved)
+	return slices.Compact(resolved)
+}
+
+// resolve makes path absolute and follows symlinks where it exists.
+func resolve(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		return real
+	}
+	return abs
+}
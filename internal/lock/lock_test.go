@@ -0,0 +1,164 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquire(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "authk.cue")
+	files := []string{filepath.Join(dir, ".env"), filepath.Join(dir, "api", ".env")}
+
+	first, err := Acquire(configPath, files)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	// The same targets in another order are the same lock
+	_, err = Acquire(configPath, []string{files[1], files[0]})
+	var lockedErr *LockedError
+	if !errors.As(err, &lockedErr) || !errors.Is(err, ErrLocked) {
+		t.Fatalf("second Acquire() error = %v, want a LockedError", err)
+	}
+	if lockedErr.Owner == nil || lockedErr.Owner.PID != os.Getpid() || lockedErr.Owner.Config != resolve(configPath) {
+		t.Errorf("unexpected owner: %+v", lockedErr.Owner)
+	}
+
+	// Another config cannot write a file of the first, even alongside others
+	_, err = Acquire(filepath.Join(dir, "other.cue"), []string{files[0], filepath.Join(dir, "other.env")})
+	if !errors.As(err, &lockedErr) || lockedErr.Target != resolve(files[0]) {
+		t.Fatalf("Acquire() for an overlapping target error = %v, want a LockedError on %s", err, files[0])
+	}
+
+	// Nor can the same config with other targets
+	if _, err := Acquire(configPath, []string{filepath.Join(dir, "other.env")}); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Acquire() for the same config error = %v, want ErrLocked", err)
+	}
+
+	// Other configs and targets are not affected, and a failed Acquire
+	// released what it had taken
+	other, err := Acquire(filepath.Join(dir, "other.cue"), []string{filepath.Join(dir, "other.env")})
+	if err != nil {
+		t.Fatalf("Acquire() for other targets error = %v", err)
+	}
+	if err := other.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	again, err := Acquire(configPath, files)
+	if err != nil {
+		t.Fatalf("Acquire() after Release() error = %v", err)
+	}
+	if err := again.Release(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetarget(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	dir := t.TempDir()
+	first, second, third := filepath.Join(dir, "first.env"), filepath.Join(dir, "second.env"), filepath.Join(dir, "third.env")
+
+	l, err := Acquire(filepath.Join(dir, "authk.cue"), []string{first, second})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	defer l.Release()
+	other, err := Acquire(filepath.Join(dir, "other.cue"), []string{third})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	// A file held by another instance is refused, and nothing changes
+	if err := l.Retarget([]string{first, third}); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Retarget() error = %v, want ErrLocked", err)
+	}
+	if _, err := Acquire(filepath.Join(dir, "third.cue"), []string{second}); !errors.Is(err, ErrLocked) {
+		t.Errorf("Acquire() of a kept file error = %v, want ErrLocked", err)
+	}
+
+	if err := other.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Retarget([]string{first, third}); err != nil {
+		t.Fatalf("Retarget() error = %v", err)
+	}
+	if _, err := Acquire(filepath.Join(dir, "third.cue"), []string{third}); !errors.Is(err, ErrLocked) {
+		t.Errorf("Acquire() of an added file error = %v, want ErrLocked", err)
+	}
+
+	// The dropped file is free again
+	released, err := Acquire(filepath.Join(dir, "third.cue"), []string{second})
+	if err != nil {
+		t.Fatalf("Acquire() of a dropped file error = %v", err)
+	}
+	if err := released.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	owner, err := ReadOwner(l.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owner.Targets) != 2 || owner.Targets[1] != resolve(third) {
+		t.Errorf("owner targets = %v, want first and third", owner.Targets)
+	}
+}
+
+func TestPath(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	dir := t.TempDir()
+	t.Chdir(dir)
+	if err := os.WriteFile("authk.cue", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	relative := Path("authk.cue")
+	absolute := Path(filepath.Join(dir, "authk.cue"))
+	if relative != absolute {
+		t.Errorf("Path() differs for relative and absolute paths: %s != %s", relative, absolute)
+	}
+	if filepath.Dir(relative) != filepath.Join("/run/user/1000", "authk") {
+		t.Errorf("Path() = %s, want a file in the runtime directory", relative)
+	}
+}
+
+func TestFind(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "authk.cue")
+
+	l, err := Acquire(configPath, []string{filepath.Join(dir, ".env")})
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if _, err := Acquire(filepath.Join(dir, "other.cue"), nil); err != nil {
+		t.Fatalf("Acquire() for another config error = %v", err)
+	}
+
+	instances, err := Find(configPath)
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if len(instances) != 1 || instances[0].PID != os.Getpid() || instances[0].Path != l.path {
+		t.Fatalf("Find() = %+v, want the instance holding %s", instances, l.path)
+	}
+	if instances[0].StatePath() != l.StatePath() {
+		t.Errorf("StatePath() = %s, want %s", instances[0].StatePath(), l.StatePath())
+	}
+
+	// The lock file is left behind, but no longer held
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if instances, err := Find(configPath); err != nil || len(instances) != 0 {
+		t.Errorf("Find() after Release() = %+v, %v, want none", instances, err)
+	}
+}
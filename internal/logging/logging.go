@@ -0,0 +1,83 @@
+// Package logging builds the logger of the authk commands from the log
+// flags: console or JSON output, to standard error or a rotated file.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/term"
+)
+
+// Formats.
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+// Options selects where and how logs are written.
+type Options struct {
+	// Format is "console" or "json"
+	Format string
+	// Level is a zerolog level such as "debug", the command default if empty
+	Level string
+	// File receives the logs instead of standard error when set
+	File string
+	// MaxSize is the size in bytes above which File is rotated, never if zero
+	MaxSize int64
+	// MaxBackups is how many rotated files are kept
+	MaxBackups int
+	// Stderr replaces standard error when set, such as io.Discard while
+	// the terminal shows a dashboard
+	Stderr io.Writer
+	// Tee, when set, also receives every entry as a JSON line
+	Tee io.Writer
+}
+
+// New returns a logger writing as opts says, at defaultLevel unless
+// opts.Level is set. The returned function closes the log file.
+func New(opts Options, defaultLevel zerolog.Level) (zerolog.Logger, func() error, error) {
+	level := defaultLevel
+	if opts.Level != "" {
+		parsed, err := zerolog.ParseLevel(opts.Level)
+		if err != nil || parsed == zerolog.NoLevel {
+			return zerolog.Logger{}, nil, fmt.Errorf("unsupported log level %q, expected trace, debug, info, warn or error", opts.Level)
+		}
+		level = parsed
+	}
+
+	var out io.Writer = os.Stderr
+	closeFile := func() error { return nil }
+	color := IsTerminal(os.Stderr)
+	if opts.Stderr != nil {
+		out, color = opts.Stderr, false
+	}
+	if opts.File != "" {
+		file, err := OpenRotating(opts.File, opts.MaxSize, opts.MaxBackups)
+		if err != nil {
+			return zerolog.Logger{}, nil, err
+		}
+		out, closeFile, color = file, file.Close, false
+	}
+
+	switch opts.Format {
+	case FormatConsole, "":
+		out = zerolog.ConsoleWriter{Out: out, NoColor: !color}
+	case FormatJSON:
+	default:
+		_ = closeFile()
+		return zerolog.Logger{}, nil, fmt.Errorf("unsupported log format %q, expected console or json", opts.Format)
+	}
+
+	if opts.Tee != nil {
+		out = zerolog.MultiLevelWriter(out, opts.Tee)
+	}
+	return zerolog.New(out).With().Timestamp().Logger().Level(level), closeFile, nil
+}
+
+// IsTerminal reports whether f is a terminal.
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
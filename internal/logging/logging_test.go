@@ -0,0 +1,88 @@
+package logging
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestNew_JSONFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "authk.log")
+	logger, closeFile, err := New(Options{Format: FormatJSON, Level: "warn", File: path}, zerolog.InfoLevel)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	logger.Info().Msg("Dropped")
+	logger.Warn().Str("file", ".env").Msg("Kept")
+	if err := closeFile(); err != nil {
+		t.Fatalf("close error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %q", data)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", lines[0], err)
+	}
+	if entry["message"] != "Kept" || entry["level"] != "warn" || entry["file"] != ".env" || entry["time"] == nil {
+		t.Errorf("unexpected entry: %v", entry)
+	}
+}
+
+func TestNew_ConsoleFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authk.log")
+	logger, closeFile, err := New(Options{File: path}, zerolog.InfoLevel)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	logger.Debug().Msg("Dropped")
+	logger.Info().Msg("Token refreshed")
+	_ = closeFile()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "INF Token refreshed") || strings.Contains(string(data), "Dropped") {
+		t.Errorf("unexpected log: %q", data)
+	}
+	if strings.Contains(string(data), "\x1b[") {
+		t.Errorf("expected no colors in a log file, got %q", data)
+	}
+}
+
+func TestNew_Tee(t *testing.T) {
+	var stderr, tee strings.Builder
+	logger, _, err := New(Options{Stderr: &stderr, Tee: &tee}, zerolog.InfoLevel)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	logger.Info().Msg("Target updated")
+
+	if !strings.Contains(stderr.String(), "INF Target updated") {
+		t.Errorf("unexpected console output: %q", stderr.String())
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(tee.String()), &entry); err != nil || entry["message"] != "Target updated" {
+		t.Errorf("expected a JSON entry, got %q", tee.String())
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	if _, _, err := New(Options{Format: "logfmt"}, zerolog.InfoLevel); err == nil {
+		t.Error("expected an error for an unsupported format")
+	}
+	if _, _, err := New(Options{Level: "verbose"}, zerolog.InfoLevel); err == nil {
+		t.Error("expected an error for an unsupported level")
+	}
+}
@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authk.log")
+	r, err := OpenRotating(path, 20, 2)
+	if err != nil {
+		t.Fatalf("OpenRotating() error = %v", err)
+	}
+	for _, line := range []string{"first line\n", "second line\n", "third line\n", "fourth line\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Every line overflows 20 bytes with the previous one, the first is
+	// beyond the backups kept
+	for file, want := range map[string]string{
+		path:        "fourth line\n",
+		path + ".1": "third line\n",
+		path + ".2": "second line\n",
+	} {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), data, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected no third backup, got %v", err)
+	}
+}
+
+func TestRotatingFile_Append(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authk.log")
+	if err := os.WriteFile(path, []byte("earlier run\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenRotating(path, 1<<20, 1)
+	if err != nil {
+		t.Fatalf("OpenRotating() error = %v", err)
+	}
+	_, _ = r.Write([]byte("this run\n"))
+	_ = r.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "earlier run\n") {
+		t.Errorf("expected the log to be appended to, got %q", data)
+	}
+	if _, err := r.Write([]byte("closed\n")); err == nil {
+		t.Error("expected writes after Close to fail")
+	}
+}
@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcomes used as label values.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Renewal methods used as label values.
+const (
+	MethodInitial          = "initial"
+	MethodRefresh          = "refresh"
+	MethodReauthentication = "reauthentication"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	tokenRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "authk_token_requests_total",
+		Help: "Token requests sent to the IdP, by grant and outcome.",
+	}, []string{"grant", "outcome"})
+
+	idpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "authk_idp_request_duration_seconds",
+		Help:    "Latency of requests to the IdP, by operation.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"operation"})
+
+	renewals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "authk_renewals_total",
+		Help: "Tokens obtained by the daemon, by method (initial, refresh, reauthentication) and outcome.",
+	}, []string{"method", "outcome"})
+
+	targetWriteFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "authk_target_write_failures_total",
+		Help: "Failed writes of the token to a target, by file.",
+	}, []string{"file"})
+
+	expiry = &expiryCollector{
+		expiresIn: prometheus.NewDesc("authk_token_expires_in_seconds",
+			"Seconds until the current token expires. Absent while the expiry is unknown.", nil, nil),
+		timestamp: prometheus.NewDesc("authk_token_expiry_timestamp_seconds",
+			"Unix time at which the current token expires. Absent while the expiry is unknown.", nil, nil),
+	}
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		tokenRequests,
+		idpRequestDuration,
+		renewals,
+		targetWriteFailures,
+		expiry,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// outcome returns the outcome label for err.
+func outcome(err error) string {
+	if err != nil {
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
+
+// ObserveTokenRequest records a token request for grant started at start.
+func ObserveTokenRequest(grant string, start time.Time, err error) {
+	tokenRequests.WithLabelValues(grant, outcome(err)).Inc()
+	idpRequestDuration.WithLabelValues("token").Observe(time.Since(start).Seconds())
+}
+
+// ObserveIdPRequest records the latency of another request to the IdP, such
+// as discovery.
+func ObserveIdPRequest(operation string, start time.Time) {
+	idpRequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveRenewal records an attempt of the daemon to obtain a token.
+func ObserveRenewal(method string, err error) {
+	renewals.WithLabelValues(method, outcome(err)).Inc()
+}
+
+// ObserveTargetWriteFailure records a failed write to the target file.
+func ObserveTargetWriteFailure(file string) {
+	targetWriteFailures.WithLabelValues(file).Inc()
+}
+
+// SetTokenExpiry records the expiry of the current token, zero if unknown.
+func SetTokenExpiry(t time.Time) {
+	expiry.mu.Lock()
+	defer expiry.mu.Unlock()
+	expiry.expiry = t
+}
+
+// expiryCollector reports the expiry of the current token, computing the
+// remaining lifetime at scrape time.
+type expiryCollector struct {
+	expiresIn *prometheus.Desc
+	timestamp *prometheus.Desc
+
+	mu     sync.Mutex
+	expiry time.Time
+}
+
+func (c *expiryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.expiresIn
+	ch <- c.timestamp
+}
+
+func (c *expiryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	expiry := c.expiry
+	c.mu.Unlock()
+	if expiry.IsZero() {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.expiresIn, prometheus.GaugeValue, time.Until(expiry).Seconds())
+	ch <- prometheus.MustNewConstMetric(c.timestamp, prometheus.GaugeValue, float64(expiry.Unix()))
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveTokenRequest(t *testing.T) {
+	before := testutil.ToFloat64(tokenRequests.WithLabelValues("password", OutcomeFailure))
+
+	ObserveTokenRequest("password", time.Now(), errors.New("invalid_grant"))
+	ObserveTokenRequest("password", time.Now(), nil)
+
+	if got := testutil.ToFloat64(tokenRequests.WithLabelValues("password", OutcomeFailure)); got != before+1 {
+		t.Errorf("failures = %v, want %v", got, before+1)
+	}
+	if got := testutil.CollectAndCount(idpRequestDuration, "authk_idp_request_duration_seconds"); got == 0 {
+		t.Error("expected IdP latency to be observed")
+	}
+}
+
+func TestExpiryCollector(t *testing.T) {
+	SetTokenExpiry(time.Time{})
+	if got := testutil.CollectAndCount(expiry); got != 0 {
+		t.Errorf("expected no expiry metrics while unknown, got %d", got)
+	}
+
+	expiresAt := time.Unix(4102444800, 0)
+	SetTokenExpiry(expiresAt)
+	expected := `
+# HELP authk_token_expiry_timestamp_seconds Unix time at which the current token expires. Absent while the expiry is unknown.
+# TYPE authk_token_expiry_timestamp_seconds gauge
+authk_token_expiry_timestamp_seconds 4.1024448e+09
+`
+	if err := testutil.CollectAndCompare(expiry, strings.NewReader(expected), "authk_token_expiry_timestamp_seconds"); err != nil {
+		t.Error(err)
+	}
+	if got := testutil.CollectAndCount(expiry); got != 2 {
+		t.Errorf("expected 2 expiry metrics, got %d", got)
+	}
+}
@@ -4,34 +4,90 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/metrics"
+	"github.com/codozor/authk/internal/tracing"
 	"github.com/rs/zerolog/log"
 
 	"github.com/coreos/go-oidc/v3/oidc"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 )
 
 type Client struct {
 	cfg          *config.Config
+	httpClient   *http.Client
 	provider     *oidc.Provider
 	oauth2Config *oauth2.Config
+	profile      profile
+	assertions   *assertionSource
+	verifyPolicy *verifyPolicy
+	skew         *skewMeter
 }
 
 func NewClient(cfg *config.Config) (*Client, error) {
 	ctx := context.Background()
 
-	// Use custom HTTP client with timeout
-	httpClient := &http.Client{Timeout: 30 * time.Second}
+	skew, err := newSkewMeter(cfg.Clock)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use custom HTTP client with timeout, measuring clock skew on the way
+	httpClient := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: tracingTransport(&skewTransport{base: http.DefaultTransport, meter: skew}),
+	}
 	ctx = oidc.ClientContext(ctx, httpClient)
 
-	provider, err := oidc.NewProvider(ctx, cfg.OIDC.IssuerURL)
+	// Resolve the provider profile. Auto-detection first looks at the issuer
+	// URL, since some quirks must be applied before discovery.
+	providerName := cfg.OIDC.Provider
+	if providerName == "" || providerName == ProviderAuto {
+		providerName = detectProvider(cfg.OIDC.IssuerURL, discoveryDocument{})
+	}
+	prof, err := lookupProfile(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	issuerURL := prof.issuerURL(cfg.OIDC.IssuerURL)
+	ctx, span := tracer.Start(ctx, "oidc.discovery", trace.WithAttributes(attrIssuer.String(issuerURL)))
+	discoveryStart := time.Now()
+	var provider *oidc.Provider
+	var doc discoveryDocument
+	if cfg.OIDC.InsecureDiscovery != nil {
+		provider, doc, err = discoverInsecure(ctx, issuerURL, cfg.OIDC.InsecureDiscovery)
+	} else {
+		if expected, ok := prof.expectedIssuer(issuerURL); ok {
+			log.Debug().Str("issuer", expected).Msg("Expecting templated issuer from discovery document")
+			ctx = oidc.InsecureIssuerURLContext(ctx, expected)
+		}
+		provider, err = oidc.NewProvider(ctx, issuerURL)
+		if err == nil {
+			// Detection goes by the issuer URL alone without the document
+			_ = provider.Claims(&doc)
+		}
+	}
+	metrics.ObserveIdPRequest("discovery", discoveryStart)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
 	}
 
+	if (cfg.OIDC.Provider == "" || cfg.OIDC.Provider == ProviderAuto) && prof.name == ProviderGeneric {
+		prof = profiles[detectProvider(issuerURL, doc)]
+	}
+	if prof.name != ProviderGeneric {
+		log.Info().Str("provider", prof.name).Msg("Using provider profile")
+	}
+	warnProfile(prof, cfg)
+
 	// Determine AuthStyle based on AuthMethod
 	var authStyle oauth2.AuthStyle
 	switch cfg.OIDC.AuthMethod {
@@ -47,22 +103,70 @@ func NewClient(cfg *config.Config) (*Client, error) {
 		ClientID:     cfg.OIDC.ClientID,
 		ClientSecret: cfg.OIDC.ClientSecret,
 		Endpoint: oauth2.Endpoint{
-			AuthURL:     provider.Endpoint().AuthURL,
-			TokenURL:    provider.Endpoint().TokenURL,
+			AuthURL:   provider.Endpoint().AuthURL,
+			TokenURL:  provider.Endpoint().TokenURL,
 			AuthStyle: authStyle, // Set AuthStyle here
 		},
-		Scopes:       cfg.OIDC.Scopes,
+		Scopes: cfg.OIDC.Scopes,
+	}
+
+	verifyPolicy, err := newVerifyPolicy(cfg, prof)
+	if err != nil {
+		return nil, err
+	}
+
+	var assertions *assertionSource
+	if cfg.SAML != nil {
+		assertions, err = newAssertionSource(cfg.SAML)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &Client{
 		cfg:          cfg,
+		httpClient:   httpClient,
 		provider:     provider,
 		oauth2Config: oauth2Config,
+		profile:      prof,
+		assertions:   assertions,
+		verifyPolicy: verifyPolicy,
+		skew:         skew,
 	}, nil
 }
 
-func (c *Client) GetToken(username, password string) (*oauth2.Token, error) {
-	ctx := context.Background()
+// warnProfile logs configuration that is known not to work with the provider.
+func warnProfile(prof profile, cfg *config.Config) {
+	switch prof.name {
+	case ProviderAuth0:
+		if cfg.OIDC.Audience == "" {
+			log.Warn().Msg("Auth0 issues opaque access tokens unless an audience is configured")
+		}
+	case ProviderEntra:
+		if cfg.OIDC.Audience == "" && cfg.User.Username == "" {
+			log.Warn().Msg("Entra ID client credentials require an audience, requested as <audience>/.default")
+		}
+	case ProviderOkta:
+		if u, err := url.Parse(cfg.OIDC.IssuerURL); err == nil && !strings.HasPrefix(u.Path, "/oauth2/") {
+			log.Warn().Msg("Okta org authorization server tokens are only valid for Okta APIs, use a custom authorization server (/oauth2/default)")
+		}
+	}
+}
+
+// GetToken obtains a new token with the grant selected by the configuration.
+// Cancelling ctx aborts the request in flight.
+func (c *Client) GetToken(ctx context.Context, username, password string) (*oauth2.Token, error) {
+	ctx, span := tracer.Start(ctx, "oidc.token", trace.WithAttributes(
+		attrIssuer.String(c.cfg.OIDC.IssuerURL),
+		attrProvider.String(c.profile.name),
+	))
+	token, err := c.getToken(ctx, username, password)
+	tracing.End(span, err)
+	return token, err
+}
+
+func (c *Client) getToken(ctx context.Context, username, password string) (*oauth2.Token, error) {
+	ctx = c.requestContext(ctx)
 
 	// Use config credentials if provided, otherwise fallback to args or client credentials
 	user := username
@@ -76,32 +180,48 @@ func (c *Client) GetToken(username, password string) (*oauth2.Token, error) {
 
 	var token *oauth2.Token
 	var err error
+	var grant string
+	requested := time.Now()
 
-	if user != "" && pass != "" {
+	if c.assertions != nil {
+		log.Info().Str("grant_type", GrantTypeSAML2Bearer).Msg("Using SAML 2.0 Bearer Assertion flow")
+		assertion, assertionErr := c.assertions.Assertion(ctx)
+		if assertionErr != nil {
+			return nil, fmt.Errorf("failed to get SAML assertion: %w", assertionErr)
+		}
+		// Do not count the assertion command as IdP latency
+		requested = time.Now()
+		grant = "saml2_bearer"
+		params := url.Values{}
+		params.Set("grant_type", GrantTypeSAML2Bearer)
+		params.Set("assertion", assertion)
+		token, err = c.exchange(ctx, params, false)
+	} else if user != "" && pass != "" {
 		log.Info().Str("grant_type", "password").Msg("Using Resource Owner Password Credentials flow")
-		token, err = c.oauth2Config.PasswordCredentialsToken(ctx, user, pass)
+		grant = "password"
+		params := url.Values{}
+		params.Set("grant_type", "password")
+		params.Set("username", user)
+		params.Set("password", pass)
+		token, err = c.exchange(ctx, params, false)
 	} else {
 		log.Info().Str("grant_type", "client_credentials").Msg("Using Client Credentials flow")
-		// For client credentials, we need to create a clientcredentials.Config
-		ccConfig := clientcredentials.Config{
-			ClientID:     c.oauth2Config.ClientID,
-			ClientSecret: c.oauth2Config.ClientSecret,
-			TokenURL:     c.oauth2Config.Endpoint.TokenURL,
-			Scopes:       c.oauth2Config.Scopes,
-			AuthStyle:    c.oauth2Config.Endpoint.AuthStyle,
-		}
-		// The clientcredentials.Config should use the http client set in the context
-		token, err = ccConfig.Token(ctx)
+		grant = "client_credentials"
+		token, err = c.exchange(ctx, url.Values{}, true)
 	}
+	metrics.ObserveTokenRequest(grant, requested, err)
+	trace.SpanFromContext(ctx).SetAttributes(attrGrantType.String(grant))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get token: %w", err)
 	}
+	c.observeToken(token, requested)
 
 	// Validate ID Token if present
 	if idTokenRaw, ok := token.Extra("id_token").(string); ok && idTokenRaw != "" {
-		verifier := c.provider.Verifier(&oidc.Config{ClientID: c.cfg.OIDC.ClientID})
-		idToken, err := verifier.Verify(ctx, idTokenRaw)
+		verifyCtx, span := tracer.Start(ctx, "oidc.verify_id_token")
+		idToken, err := c.verifyPolicy.verify(verifyCtx, c.provider, idTokenRaw)
+		tracing.End(span, err)
 		if err != nil {
 			return nil, fmt.Errorf("failed to verify ID token: %w", err)
 		}
@@ -116,15 +236,79 @@ func (c *Client) GetToken(username, password string) (*oauth2.Token, error) {
 	return token, nil
 }
 
-// RefreshToken refreshes an expired token using the oauth2 library.
-// It takes the existing *oauth2.Token which must contain a valid RefreshToken.
-func (c *Client) RefreshToken(oldToken *oauth2.Token) (*oauth2.Token, error) {
-	ctx := context.Background()
+// exchange sends a token request with the given form parameters, adding the
+// scopes and parameters required by the provider profile. The grant type
+// defaults to client_credentials and may be overridden through params.
+func (c *Client) exchange(ctx context.Context, params url.Values, clientCredentials bool) (*oauth2.Token, error) {
+	for k, v := range c.profile.endpointParams(c.cfg.OIDC.Audience) {
+		params[k] = v
+	}
+
+	// clientcredentials.Config allows grant_type to be overridden, which lets
+	// every grant share its request building and authentication handling.
+	ccConfig := clientcredentials.Config{
+		ClientID:       c.oauth2Config.ClientID,
+		ClientSecret:   c.oauth2Config.ClientSecret,
+		TokenURL:       c.oauth2Config.Endpoint.TokenURL,
+		Scopes:         c.profile.scopes(c.oauth2Config.Scopes, c.cfg.OIDC.Audience, clientCredentials),
+		EndpointParams: params,
+		AuthStyle:      c.oauth2Config.Endpoint.AuthStyle,
+	}
+	// The clientcredentials.Config should use the http client set in the context
+	return ccConfig.Token(ctx)
+}
+
+// RefreshExpiry returns when the refresh token of token expires, or the zero
+// time if the provider does not report it.
+func (c *Client) RefreshExpiry(token *oauth2.Token) time.Time {
+	return c.profile.refreshExpiry(token)
+}
 
-	tokenSource := c.oauth2Config.TokenSource(ctx, oldToken)
-	newToken, err := tokenSource.Token()
+// RefreshToken refreshes a token using the oauth2 library, whether or not it
+// has expired yet. It takes the existing *oauth2.Token which must contain a
+// valid RefreshToken.
+func (c *Client) RefreshToken(ctx context.Context, oldToken *oauth2.Token) (newToken *oauth2.Token, err error) {
+	ctx, span := tracer.Start(ctx, "oidc.refresh", trace.WithAttributes(
+		attrIssuer.String(c.cfg.OIDC.IssuerURL),
+		attrProvider.String(c.profile.name),
+		attrGrantType.String("refresh_token"),
+	))
+	defer func() { tracing.End(span, err) }()
+	ctx = c.requestContext(ctx)
+
+	// Only pass the refresh token: the token source would hand back an access
+	// token that has not expired yet instead of refreshing it.
+	tokenSource := c.oauth2Config.TokenSource(ctx, &oauth2.Token{RefreshToken: oldToken.RefreshToken})
+	requested := time.Now()
+	newToken, err = tokenSource.Token()
+	metrics.ObserveTokenRequest("refresh_token", requested, err)
 	if err != nil {
 		return nil, fmt.Errorf("failed to refresh token: %w", err)
 	}
+	c.observeToken(newToken, requested)
 	return newToken, nil
 }
+
+// observeToken measures the clock skew from the iat claim of a token
+// requested at requested, unless the response carried a Date header, and
+// fills in its expiry.
+func (c *Client) observeToken(token *oauth2.Token, requested time.Time) {
+	if !c.skew.measuredSince(requested) {
+		if skew, ok := iatSkew(token.AccessToken, time.Now()); ok {
+			c.skew.observe(skew, "iat")
+		}
+	}
+	fillExpiry(token, c.skew.Skew())
+}
+
+// ClockSkew returns the last measured offset between the IdP clock and the
+// local clock, positive when the IdP is ahead.
+func (c *Client) ClockSkew() time.Duration {
+	return c.skew.Skew()
+}
+
+// requestContext makes the oauth2 library send token requests through the
+// client's HTTP client.
+func (c *Client) requestContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, c.httpClient)
+}
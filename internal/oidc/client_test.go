@@ -1,6 +1,7 @@
 package oidc
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -75,7 +76,7 @@ func TestClient_GetToken(t *testing.T) {
 		t.Fatalf("NewClient() error = %v", err)
 	}
 
-	token, err := client.GetToken("", "")
+	token, err := client.GetToken(context.Background(), "", "")
 	if err != nil {
 		t.Fatalf("GetToken() error = %v", err)
 	}
@@ -145,7 +146,7 @@ func TestClient_GetToken_Password(t *testing.T) {
 		t.Fatalf("NewClient() error = %v", err)
 	}
 
-	token, err := client.GetToken("testuser", "testpass")
+	token, err := client.GetToken(context.Background(), "testuser", "testpass")
 	if err != nil {
 		t.Fatalf("GetToken() error = %v", err)
 	}
@@ -216,12 +217,14 @@ func TestClient_RefreshToken(t *testing.T) {
 	}
 
 	// Create a dummy old token with the refresh token
+	// Not expired yet: the daemon refreshes ahead of expiry
 	oldToken := &oauth2.Token{
+		AccessToken:  "old_access_token",
 		RefreshToken: "valid_refresh",
-		Expiry:       time.Now().Add(-1 * time.Hour), // Expired to force refresh
+		Expiry:       time.Now().Add(30 * time.Second),
 	}
 
-	token, err := client.RefreshToken(oldToken)
+	token, err := client.RefreshToken(context.Background(), oldToken)
 	if err != nil {
 		t.Fatalf("RefreshToken() error = %v", err)
 	}
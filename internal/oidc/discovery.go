@@ -0,0 +1,103 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/codozor/authk/internal/config"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/rs/zerolog/log"
+)
+
+// providerEndpoints holds the discovery fields needed to rebuild a provider
+// with rewritten endpoints.
+type providerEndpoints struct {
+	Issuer        string   `json:"issuer"`
+	AuthURL       string   `json:"authorization_endpoint"`
+	TokenURL      string   `json:"token_endpoint"`
+	DeviceAuthURL string   `json:"device_authorization_endpoint"`
+	UserInfoURL   string   `json:"userinfo_endpoint"`
+	JWKSURL       string   `json:"jwks_uri"`
+	Algorithms    []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// discoverInsecure discovers the provider through a URL that differs from its
+// issuer, as with IdPs running in containers that advertise an internal
+// hostname. The discovered issuer must still match issuer. Endpoints on the
+// issuer's host are rewritten to the discovery host when requested. The
+// discovery document is returned for provider detection, since a provider
+// with rewritten endpoints no longer carries it.
+func discoverInsecure(ctx context.Context, issuer string, discovery *config.InsecureDiscoveryConfig) (*oidc.Provider, discoveryDocument, error) {
+	log.Warn().
+		Str("discovery_url", discovery.URL).
+		Str("issuer", issuer).
+		Bool("rewrite_hosts", discovery.RewriteHosts).
+		Msg("Relaxed-security discovery enabled, do not use outside local development")
+
+	discoveryURL := strings.TrimSuffix(discovery.URL, "/.well-known/openid-configuration")
+	provider, err := oidc.NewProvider(oidc.InsecureIssuerURLContext(ctx, issuer), discoveryURL)
+	if err != nil {
+		return nil, discoveryDocument{}, err
+	}
+
+	var endpoints providerEndpoints
+	if err := provider.Claims(&endpoints); err != nil {
+		return nil, discoveryDocument{}, fmt.Errorf("failed to decode discovery document: %w", err)
+	}
+	var doc discoveryDocument
+	if err := provider.Claims(&doc); err != nil {
+		return nil, discoveryDocument{}, fmt.Errorf("failed to decode discovery document: %w", err)
+	}
+	// InsecureIssuerURLContext skips the issuer check entirely, so it is
+	// done here against the configured issuer instead.
+	if endpoints.Issuer != issuer {
+		return nil, discoveryDocument{}, fmt.Errorf("issuer %q returned by %s does not match configured issuer %q", endpoints.Issuer, discovery.URL, issuer)
+	}
+
+	if !discovery.RewriteHosts {
+		return provider, doc, nil
+	}
+
+	from, err := url.Parse(issuer)
+	if err != nil {
+		return nil, discoveryDocument{}, fmt.Errorf("invalid issuer URL: %w", err)
+	}
+	to, err := url.Parse(discoveryURL)
+	if err != nil {
+		return nil, discoveryDocument{}, fmt.Errorf("invalid discovery URL: %w", err)
+	}
+
+	providerConfig := &oidc.ProviderConfig{
+		IssuerURL:     issuer,
+		AuthURL:       rewriteHost(endpoints.AuthURL, from, to),
+		TokenURL:      rewriteHost(endpoints.TokenURL, from, to),
+		DeviceAuthURL: rewriteHost(endpoints.DeviceAuthURL, from, to),
+		UserInfoURL:   rewriteHost(endpoints.UserInfoURL, from, to),
+		JWKSURL:       rewriteHost(endpoints.JWKSURL, from, to),
+		Algorithms:    endpoints.Algorithms,
+	}
+	log.Debug().
+		Str("token_url", providerConfig.TokenURL).
+		Str("jwks_url", providerConfig.JWKSURL).
+		Msg("Rewrote provider endpoints")
+
+	return providerConfig.NewProvider(ctx), doc, nil
+}
+
+// rewriteHost replaces the scheme and host of endpoint with those of to when
+// endpoint is served from the same host as from. Other endpoints are returned
+// unchanged.
+func rewriteHost(endpoint string, from, to *url.URL) string {
+	if endpoint == "" {
+		return endpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || !strings.EqualFold(u.Host, from.Host) {
+		return endpoint
+	}
+	u.Scheme = to.Scheme
+	u.Host = to.Host
+	return u.String()
+}
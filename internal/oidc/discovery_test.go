@@ -0,0 +1,161 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/codozor/authk/internal/config"
+)
+
+func TestClient_InsecureDiscovery(t *testing.T) {
+	const issuer = "http://keycloak:8080/realms/dev"
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/realms/dev/.well-known/openid-configuration":
+			// The IdP advertises its container hostname everywhere
+			if err := json.NewEncoder(w).Encode(map[string]interface{}{
+				"issuer":                                issuer,
+				"token_endpoint":                        issuer + "/protocol/openid-connect/token",
+				"jwks_uri":                              issuer + "/protocol/openid-connect/certs",
+				"response_types_supported":              []string{"code"},
+				"subject_types_supported":               []string{"public"},
+				"id_token_signing_alg_values_supported": []string{"RS256"},
+			}); err != nil {
+				t.Error(err)
+			}
+		case "/realms/dev/protocol/openid-connect/token":
+			w.Header().Set("Content-Type", "application/json")
+			resp := mockTokenResponse{
+				AccessToken: "rewritten_access_token",
+				ExpiresIn:   3600,
+				TokenType:   "Bearer",
+			}
+			if err := json.NewEncoder(w).Encode(resp); err != nil {
+				t.Error(err)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	testServer := httptest.NewServer(handler)
+	defer testServer.Close()
+
+	newConfig := func(issuerURL string, rewriteHosts bool) *config.Config {
+		return &config.Config{
+			OIDC: config.OIDCConfig{
+				IssuerURL:    issuerURL,
+				ClientID:     "client",
+				ClientSecret: "secret",
+				AuthMethod:   "client_secret_basic",
+				InsecureDiscovery: &config.InsecureDiscoveryConfig{
+					URL:          testServer.URL + "/realms/dev",
+					RewriteHosts: rewriteHosts,
+				},
+			},
+		}
+	}
+
+	t.Run("Rewrite hosts", func(t *testing.T) {
+		client, err := NewClient(newConfig(issuer, true))
+		if err != nil {
+			t.Fatalf("NewClient() error = %v", err)
+		}
+
+		if !strings.HasPrefix(client.oauth2Config.Endpoint.TokenURL, testServer.URL) {
+			t.Errorf("expected token URL on %s, got %s", testServer.URL, client.oauth2Config.Endpoint.TokenURL)
+		}
+
+		token, err := client.GetToken(context.Background(), "", "")
+		if err != nil {
+			t.Fatalf("GetToken() error = %v", err)
+		}
+		if token.AccessToken != "rewritten_access_token" {
+			t.Errorf("expected access token 'rewritten_access_token', got %s", token.AccessToken)
+		}
+	})
+
+	t.Run("Keep hosts", func(t *testing.T) {
+		client, err := NewClient(newConfig(issuer, false))
+		if err != nil {
+			t.Fatalf("NewClient() error = %v", err)
+		}
+		if want := issuer + "/protocol/openid-connect/token"; client.oauth2Config.Endpoint.TokenURL != want {
+			t.Errorf("expected token URL %s, got %s", want, client.oauth2Config.Endpoint.TokenURL)
+		}
+	})
+
+	t.Run("Issuer mismatch", func(t *testing.T) {
+		if _, err := NewClient(newConfig("http://other:8080/realms/dev", true)); err == nil {
+			t.Error("NewClient() expected error for mismatched issuer, got nil")
+		}
+	})
+}
+
+func TestClient_InsecureDiscovery_DetectProvider(t *testing.T) {
+	const issuer = "http://zitadel:8080"
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/openid-configuration" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// Only the discovery document tells the provider apart
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                issuer,
+			"token_endpoint":                        issuer + "/oauth/v2/token",
+			"jwks_uri":                              issuer + "/oauth/v2/keys",
+			"scopes_supported":                      []string{"openid", "urn:zitadel:iam:org:project:id:zitadel:aud"},
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		}); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer testServer.Close()
+
+	for _, rewriteHosts := range []bool{true, false} {
+		client, err := NewClient(&config.Config{
+			OIDC: config.OIDCConfig{
+				IssuerURL: issuer,
+				ClientID:  "client",
+				InsecureDiscovery: &config.InsecureDiscoveryConfig{
+					URL:          testServer.URL,
+					RewriteHosts: rewriteHosts,
+				},
+			},
+		})
+		if err != nil {
+			t.Fatalf("NewClient() error = %v", err)
+		}
+		if client.profile.name != ProviderZitadel {
+			t.Errorf("rewriteHosts %v: profile = %s, want %s", rewriteHosts, client.profile.name, ProviderZitadel)
+		}
+	}
+}
+
+func TestRewriteHost(t *testing.T) {
+	from, _ := url.Parse("http://keycloak:8080/realms/dev")
+	to, _ := url.Parse("https://localhost:8443/realms/dev")
+
+	tests := []struct {
+		endpoint string
+		want     string
+	}{
+		{"http://keycloak:8080/realms/dev/protocol/openid-connect/certs", "https://localhost:8443/realms/dev/protocol/openid-connect/certs"},
+		{"https://cdn.example.com/jwks.json", "https://cdn.example.com/jwks.json"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := rewriteHost(tt.endpoint, from, to); got != tt.want {
+			t.Errorf("rewriteHost(%q) = %s, want %s", tt.endpoint, got, tt.want)
+		}
+	}
+}
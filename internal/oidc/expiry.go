@@ -0,0 +1,23 @@
+package oidc
+
+import (
+	"time"
+
+	"github.com/codozor/authk/internal/jwtclaims"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+)
+
+// fillExpiry sets the expiry of token from the exp claim of its access token
+// when the response had no expires_in. The claim is in IdP time, so it is
+// converted to the local clock with skew. Opaque access tokens are left as
+// is, and the daemon then schedules refreshes without knowing the lifetime.
+func fillExpiry(token *oauth2.Token, skew time.Duration) {
+	if !token.Expiry.IsZero() {
+		return
+	}
+	if expiry := jwtclaims.Parse(token.AccessToken).Time("exp"); !expiry.IsZero() {
+		token.Expiry = expiry.Add(-skew)
+		log.Debug().Time("expiry", token.Expiry).Msg("No expires_in in token response, using the exp claim of the access token")
+	}
+}
@@ -0,0 +1,52 @@
+package oidc
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func unsignedJWT(payload string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	return header + "." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".sig"
+}
+
+func TestFillExpiry(t *testing.T) {
+	exp := time.Unix(1735732800, 0)
+
+	tests := []struct {
+		name   string
+		token  *oauth2.Token
+		expect time.Time
+	}{
+		{
+			name:   "JWT without expires_in",
+			token:  &oauth2.Token{AccessToken: unsignedJWT(`{"sub":"me","exp":1735732800}`)},
+			expect: exp,
+		},
+		{
+			name:   "expires_in takes precedence",
+			token:  &oauth2.Token{AccessToken: unsignedJWT(`{"exp":1735732800}`), Expiry: exp.Add(time.Hour)},
+			expect: exp.Add(time.Hour),
+		},
+		{
+			name:  "Opaque token",
+			token: &oauth2.Token{AccessToken: "opaque"},
+		},
+		{
+			name:  "JWT without exp",
+			token: &oauth2.Token{AccessToken: unsignedJWT(`{"sub":"me"}`)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fillExpiry(tt.token, 0)
+			if !tt.token.Expiry.Equal(tt.expect) {
+				t.Errorf("Expiry = %s, want %s", tt.token.Expiry, tt.expect)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package oidc
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Provider names accepted by the "provider" config field.
+const (
+	ProviderAuto      = "auto"
+	ProviderGeneric   = "generic"
+	ProviderKeycloak  = "keycloak"
+	ProviderAuth0     = "auth0"
+	ProviderEntra     = "entra"
+	ProviderOkta      = "okta"
+	ProviderAuthentik = "authentik"
+	ProviderZitadel   = "zitadel"
+)
+
+// profile describes the known deviations of an identity provider from plain
+// OIDC. The zero value behaves like a spec-compliant provider.
+type profile struct {
+	name string
+
+	// audienceParam sends the configured audience as an "audience" form
+	// parameter on token requests.
+	audienceParam bool
+	// audienceScope turns the configured audience into an extra scope.
+	audienceScope func(audience string) string
+	// resourceScopesOnly drops the OIDC identity scopes from client
+	// credentials requests, which some providers reject outright.
+	resourceScopesOnly bool
+	// refreshExpiresIn reports that token responses carry the lifetime of the
+	// refresh token in "refresh_expires_in".
+	refreshExpiresIn bool
+	// trailingSlashIssuer reports that the provider's issuer always ends with
+	// a slash, which users routinely leave out.
+	trailingSlashIssuer bool
+}
+
+var profiles = map[string]profile{
+	ProviderGeneric: {
+		name:          ProviderGeneric,
+		audienceParam: true,
+	},
+	ProviderKeycloak: {
+		name:             ProviderKeycloak,
+		refreshExpiresIn: true,
+	},
+	ProviderAuth0: {
+		name:          ProviderAuth0,
+		audienceParam: true,
+	},
+	ProviderEntra: {
+		name: ProviderEntra,
+		audienceScope: func(audience string) string {
+			return strings.TrimSuffix(audience, "/.default") + "/.default"
+		},
+		resourceScopesOnly: true,
+	},
+	ProviderOkta: {
+		name:               ProviderOkta,
+		resourceScopesOnly: true,
+	},
+	ProviderAuthentik: {
+		name:                ProviderAuthentik,
+		trailingSlashIssuer: true,
+	},
+	ProviderZitadel: {
+		name: ProviderZitadel,
+		audienceScope: func(audience string) string {
+			return fmt.Sprintf("urn:zitadel:iam:org:project:id:%s:aud", audience)
+		},
+	},
+}
+
+// oidcScopes are the identity scopes dropped by resourceScopesOnly.
+var oidcScopes = map[string]bool{
+	"openid":         true,
+	"profile":        true,
+	"email":          true,
+	"address":        true,
+	"phone":          true,
+	"offline_access": true,
+}
+
+// entraTenantAliases are the Entra ID tenant path segments whose discovery
+// document advertises a "{tenantid}" placeholder instead of a real issuer.
+var entraTenantAliases = map[string]bool{
+	"common":        true,
+	"organizations": true,
+	"consumers":     true,
+}
+
+// discoveryDocument holds the discovery fields used for provider detection.
+type discoveryDocument struct {
+	Issuer          string   `json:"issuer"`
+	TokenEndpoint   string   `json:"token_endpoint"`
+	ScopesSupported []string `json:"scopes_supported"`
+}
+
+// detectProvider guesses the provider from its issuer URL and, when
+// available, its discovery document. It returns ProviderGeneric when nothing
+// matches.
+func detectProvider(issuerURL string, doc discoveryDocument) string {
+	host := ""
+	if u, err := url.Parse(issuerURL); err == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+
+	switch {
+	case host == "login.microsoftonline.com", host == "sts.windows.net", host == "login.microsoftonline.us":
+		return ProviderEntra
+	case strings.HasSuffix(host, ".auth0.com"):
+		return ProviderAuth0
+	case strings.HasSuffix(host, ".okta.com"), strings.HasSuffix(host, ".oktapreview.com"), strings.HasSuffix(host, ".okta-emea.com"):
+		return ProviderOkta
+	case strings.HasSuffix(host, ".zitadel.cloud"):
+		return ProviderZitadel
+	case strings.Contains(issuerURL, "/application/o/"):
+		return ProviderAuthentik
+	case strings.Contains(issuerURL, "/realms/"):
+		return ProviderKeycloak
+	}
+
+	switch {
+	case strings.Contains(doc.TokenEndpoint, "/protocol/openid-connect/token"):
+		return ProviderKeycloak
+	case strings.Contains(doc.TokenEndpoint, "/application/o/token"):
+		return ProviderAuthentik
+	case strings.HasSuffix(doc.TokenEndpoint, "/oauth/token"):
+		return ProviderAuth0
+	}
+	for _, scope := range doc.ScopesSupported {
+		if strings.HasPrefix(scope, "urn:zitadel:") {
+			return ProviderZitadel
+		}
+	}
+
+	return ProviderGeneric
+}
+
+// lookupProfile returns the profile registered under name.
+func lookupProfile(name string) (profile, error) {
+	p, ok := profiles[name]
+	if !ok {
+		return profile{}, fmt.Errorf("unsupported provider: %s", name)
+	}
+	return p, nil
+}
+
+// issuerURL returns the issuer URL to use for discovery.
+func (p profile) issuerURL(issuer string) string {
+	if p.trailingSlashIssuer && !strings.HasSuffix(issuer, "/") {
+		return issuer + "/"
+	}
+	return issuer
+}
+
+// expectedIssuer returns the issuer the discovery document is expected to
+// advertise, if it differs from the issuer URL itself.
+func (p profile) expectedIssuer(issuer string) (string, bool) {
+	if p.name != ProviderEntra {
+		return "", false
+	}
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return "", false
+	}
+	segments := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+	if len(segments) == 0 || !entraTenantAliases[segments[0]] {
+		return "", false
+	}
+	segments[0] = "{tenantid}"
+	return u.Scheme + "://" + u.Host + "/" + strings.Join(segments, "/"), true
+}
+
+// matchIssuer reports whether issuer matches a templated issuer returned by
+// expectedIssuer, the "{tenantid}" placeholder standing for a single path
+// segment.
+func matchIssuer(template, issuer string) bool {
+	prefix, suffix, ok := strings.Cut(template, "{tenantid}")
+	if !ok {
+		return issuer == template
+	}
+	if len(issuer) < len(prefix)+len(suffix) || !strings.HasPrefix(issuer, prefix) || !strings.HasSuffix(issuer, suffix) {
+		return false
+	}
+	tenant := issuer[len(prefix) : len(issuer)-len(suffix)]
+	return tenant != "" && !strings.Contains(tenant, "/")
+}
+
+// scopes returns the scopes to request for the given grant.
+func (p profile) scopes(scopes []string, audience string, clientCredentials bool) []string {
+	result := make([]string, 0, len(scopes)+1)
+	for _, scope := range scopes {
+		if clientCredentials && p.resourceScopesOnly && oidcScopes[scope] {
+			continue
+		}
+		result = append(result, scope)
+	}
+	if audience != "" && p.audienceScope != nil {
+		result = append(result, p.audienceScope(audience))
+	}
+	return result
+}
+
+// endpointParams returns the extra form parameters to send on token requests.
+func (p profile) endpointParams(audience string) url.Values {
+	params := url.Values{}
+	if audience != "" && p.audienceParam {
+		params.Set("audience", audience)
+	}
+	return params
+}
+
+// refreshExpiry returns when the refresh token of token expires, or the zero
+// time if the provider does not report it.
+func (p profile) refreshExpiry(token *oauth2.Token) time.Time {
+	if !p.refreshExpiresIn || token.Expiry.IsZero() {
+		return time.Time{}
+	}
+	refreshExpiresIn, ok := numberExtra(token, "refresh_expires_in")
+	// Keycloak reports 0 for offline tokens, which never expire.
+	if !ok || refreshExpiresIn <= 0 {
+		return time.Time{}
+	}
+	expiresIn, ok := numberExtra(token, "expires_in")
+	if !ok {
+		return time.Time{}
+	}
+	issuedAt := token.Expiry.Add(-time.Duration(expiresIn) * time.Second)
+	return issuedAt.Add(time.Duration(refreshExpiresIn) * time.Second)
+}
+
+// numberExtra reads a numeric field from the raw token response.
+func numberExtra(token *oauth2.Token, key string) (int64, bool) {
+	switch v := token.Extra(key).(type) {
+	case float64:
+		return int64(v), true
+	case int64:
+		return v, true
+	case string:
+		var n int64
+		if _, err := fmt.Sscan(v, &n); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,210 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"golang.org/x/oauth2"
+)
+
+func TestDetectProvider(t *testing.T) {
+	tests := []struct {
+		name   string
+		issuer string
+		doc    discoveryDocument
+		want   string
+	}{
+		{name: "Entra", issuer: "https://login.microsoftonline.com/common/v2.0", want: ProviderEntra},
+		{name: "Auth0", issuer: "https://tenant.eu.auth0.com/", want: ProviderAuth0},
+		{name: "Okta", issuer: "https://dev-123.okta.com/oauth2/default", want: ProviderOkta},
+		{name: "Zitadel cloud", issuer: "https://my-instance.zitadel.cloud", want: ProviderZitadel},
+		{name: "Authentik", issuer: "https://authentik.example.com/application/o/my-app/", want: ProviderAuthentik},
+		{name: "Keycloak", issuer: "https://keycloak.example.com/realms/myrealm", want: ProviderKeycloak},
+		{
+			name:   "Keycloak from discovery",
+			issuer: "https://sso.example.com/auth",
+			doc:    discoveryDocument{TokenEndpoint: "https://sso.example.com/auth/protocol/openid-connect/token"},
+			want:   ProviderKeycloak,
+		},
+		{
+			name:   "Auth0 custom domain from discovery",
+			issuer: "https://login.example.com/",
+			doc:    discoveryDocument{TokenEndpoint: "https://login.example.com/oauth/token"},
+			want:   ProviderAuth0,
+		},
+		{
+			name:   "Zitadel self-hosted from discovery",
+			issuer: "https://id.example.com",
+			doc:    discoveryDocument{ScopesSupported: []string{"openid", "urn:zitadel:iam:org:project:id:zitadel:aud"}},
+			want:   ProviderZitadel,
+		},
+		{name: "Generic", issuer: "https://id.example.com", want: ProviderGeneric},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectProvider(tt.issuer, tt.doc); got != tt.want {
+				t.Errorf("detectProvider() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfile_Scopes(t *testing.T) {
+	scopes := []string{"openid", "profile", "api"}
+
+	tests := []struct {
+		name              string
+		provider          string
+		audience          string
+		clientCredentials bool
+		want              []string
+	}{
+		{name: "Generic", provider: ProviderGeneric, audience: "api://x", clientCredentials: true, want: []string{"openid", "profile", "api"}},
+		{name: "Entra client credentials", provider: ProviderEntra, audience: "api://x", clientCredentials: true, want: []string{"api", "api://x/.default"}},
+		{name: "Entra password", provider: ProviderEntra, audience: "api://x/.default", want: []string{"openid", "profile", "api", "api://x/.default"}},
+		{name: "Okta client credentials", provider: ProviderOkta, clientCredentials: true, want: []string{"api"}},
+		{name: "Zitadel", provider: ProviderZitadel, audience: "123", want: []string{"openid", "profile", "api", "urn:zitadel:iam:org:project:id:123:aud"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := profiles[tt.provider].scopes(scopes, tt.audience, tt.clientCredentials)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProfile_ExpectedIssuer(t *testing.T) {
+	got, ok := profiles[ProviderEntra].expectedIssuer("https://login.microsoftonline.com/organizations/v2.0")
+	if !ok {
+		t.Fatal("expectedIssuer() expected a templated issuer for the organizations tenant")
+	}
+	if want := "https://login.microsoftonline.com/{tenantid}/v2.0"; got != want {
+		t.Errorf("expectedIssuer() = %s, want %s", got, want)
+	}
+
+	if _, ok := profiles[ProviderEntra].expectedIssuer("https://login.microsoftonline.com/0000-1111/v2.0"); ok {
+		t.Error("expectedIssuer() should not template a concrete tenant")
+	}
+}
+
+func TestMatchIssuer(t *testing.T) {
+	const template = "https://login.microsoftonline.com/{tenantid}/v2.0"
+	tests := []struct {
+		issuer string
+		want   bool
+	}{
+		{issuer: "https://login.microsoftonline.com/0000-1111/v2.0", want: true},
+		{issuer: "https://login.microsoftonline.com//v2.0", want: false},
+		{issuer: "https://login.microsoftonline.com/0000/1111/v2.0", want: false},
+		{issuer: "https://sts.windows.net/0000-1111/", want: false},
+		{issuer: "https://evil.example.com/0000-1111/v2.0", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := matchIssuer(template, tt.issuer); got != tt.want {
+			t.Errorf("matchIssuer(%q) = %v, want %v", tt.issuer, got, tt.want)
+		}
+	}
+}
+
+func TestProfile_RefreshExpiry(t *testing.T) {
+	expiry := time.Now().Add(5 * time.Minute).Truncate(time.Second)
+	token := (&oauth2.Token{AccessToken: "a", Expiry: expiry}).WithExtra(map[string]interface{}{
+		"expires_in":         float64(300),
+		"refresh_expires_in": float64(1800),
+	})
+
+	got := profiles[ProviderKeycloak].refreshExpiry(token)
+	if want := expiry.Add(25 * time.Minute); !got.Equal(want) {
+		t.Errorf("refreshExpiry() = %s, want %s", got, want)
+	}
+
+	if got := profiles[ProviderGeneric].refreshExpiry(token); !got.IsZero() {
+		t.Errorf("refreshExpiry() for generic provider = %s, want zero", got)
+	}
+
+	offline := (&oauth2.Token{AccessToken: "a", Expiry: expiry}).WithExtra(map[string]interface{}{
+		"expires_in":         float64(300),
+		"refresh_expires_in": float64(0),
+	})
+	if got := profiles[ProviderKeycloak].refreshExpiry(offline); !got.IsZero() {
+		t.Errorf("refreshExpiry() for offline token = %s, want zero", got)
+	}
+}
+
+func TestClient_GetToken_Auth0Audience(t *testing.T) {
+	var testServer *httptest.Server
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			if err := json.NewEncoder(w).Encode(map[string]interface{}{
+				"issuer":                                testServer.URL + "/",
+				"token_endpoint":                        testServer.URL + "/oauth/token",
+				"jwks_uri":                              testServer.URL + "/.well-known/jwks.json",
+				"response_types_supported":              []string{"code"},
+				"subject_types_supported":               []string{"public"},
+				"id_token_signing_alg_values_supported": []string{"RS256"},
+			}); err != nil {
+				t.Error(err)
+			}
+		case "/oauth/token":
+			if err := r.ParseForm(); err != nil {
+				t.Error(err)
+			}
+			if r.Form.Get("grant_type") == "password" && r.Form.Get("audience") == "https://api.example.com" {
+				w.Header().Set("Content-Type", "application/json")
+				resp := mockTokenResponse{
+					AccessToken: "auth0_access_token",
+					ExpiresIn:   3600,
+					TokenType:   "Bearer",
+				}
+				if err := json.NewEncoder(w).Encode(resp); err != nil {
+					t.Error(err)
+				}
+			} else {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	testServer = httptest.NewServer(handler)
+	defer testServer.Close()
+
+	cfg := &config.Config{
+		OIDC: config.OIDCConfig{
+			IssuerURL:    testServer.URL + "/",
+			ClientID:     "client",
+			ClientSecret: "secret",
+			AuthMethod:   "client_secret_post",
+			Audience:     "https://api.example.com",
+		},
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if client.profile.name != ProviderAuth0 {
+		t.Errorf("expected auto-detected provider %s, got %s", ProviderAuth0, client.profile.name)
+	}
+
+	token, err := client.GetToken(context.Background(), "testuser", "testpass")
+	if err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+
+	if token.AccessToken != "auth0_access_token" {
+		t.Errorf("expected access token 'auth0_access_token', got %s", token.AccessToken)
+	}
+}
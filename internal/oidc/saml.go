@@ -0,0 +1,123 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/retry"
+)
+
+// GrantTypeSAML2Bearer is the grant type of the SAML 2.0 bearer assertion
+// grant defined in RFC 7522.
+const GrantTypeSAML2Bearer = "urn:ietf:params:oauth:grant-type:saml2-bearer"
+
+// assertionCommandTimeout bounds how long an assertion command may run.
+const assertionCommandTimeout = 30 * time.Second
+
+// assertionSource reads SAML assertions from a file, a command or stdin.
+type assertionSource struct {
+	file    string
+	command []string
+	stdin   bool
+
+	// stdin can only be read once, and assertions expire within minutes, so
+	// an assertion from stdin serves a single grant
+	mu        sync.Mutex
+	stdinRead bool
+}
+
+func newAssertionSource(cfg *config.SAMLConfig) (*assertionSource, error) {
+	sources := 0
+	if cfg.AssertionFile != "" {
+		sources++
+	}
+	if len(cfg.AssertionCommand) > 0 {
+		sources++
+	}
+	if cfg.AssertionStdin {
+		sources++
+	}
+	if sources != 1 {
+		return nil, fmt.Errorf("saml: exactly one of assertionFile, assertionCommand or assertionStdin must be set")
+	}
+
+	return &assertionSource{
+		file:    cfg.AssertionFile,
+		command: cfg.AssertionCommand,
+		stdin:   cfg.AssertionStdin,
+	}, nil
+}
+
+// Assertion returns the assertion, base64url-encoded as required by RFC 7522.
+func (s *assertionSource) Assertion(ctx context.Context) (string, error) {
+	raw, err := s.read(ctx)
+	if err != nil {
+		return "", err
+	}
+	return encodeAssertion(raw)
+}
+
+func (s *assertionSource) read(ctx context.Context) ([]byte, error) {
+	switch {
+	case s.file != "":
+		data, err := os.ReadFile(s.file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read assertion file: %w", err)
+		}
+		return data, nil
+	case len(s.command) > 0:
+		ctx, cancel := context.WithTimeout(ctx, assertionCommandTimeout)
+		defer cancel()
+
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, s.command[0], s.command[1:]...)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("assertion command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return out, nil
+	default:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.stdinRead {
+			return nil, retry.Permanent(errors.New("the assertion from stdin was already used, set assertionFile or assertionCommand to re-authenticate"))
+		}
+		s.stdinRead = true
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read assertion from stdin: %w", err)
+		}
+		return data, nil
+	}
+}
+
+// encodeAssertion base64url-encodes a raw XML assertion. Input that is not
+// XML is assumed to be encoded already, possibly line-wrapped, and is
+// normalised to base64url.
+func encodeAssertion(raw []byte) (string, error) {
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 {
+		return "", fmt.Errorf("empty SAML assertion")
+	}
+	if trimmed[0] == '<' {
+		return base64.RawURLEncoding.EncodeToString(trimmed), nil
+	}
+
+	encoded := strings.TrimRight(strings.Join(strings.Fields(string(trimmed)), ""), "=")
+	encoded = strings.NewReplacer("+", "-", "/", "_").Replace(encoded)
+	if _, err := base64.RawURLEncoding.DecodeString(encoded); err != nil {
+		return "", fmt.Errorf("SAML assertion is neither XML nor base64: %w", err)
+	}
+	return encoded, nil
+}
@@ -0,0 +1,183 @@
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/retry"
+)
+
+const testAssertion = `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_1"/>`
+
+func TestClient_GetToken_SAML2Bearer(t *testing.T) {
+	// Stand-in authorization server that only accepts the SAML bearer grant
+	var testServer *httptest.Server
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			if err := json.NewEncoder(w).Encode(map[string]interface{}{
+				"issuer":                                testServer.URL,
+				"token_endpoint":                        testServer.URL + "/token",
+				"jwks_uri":                              testServer.URL + "/certs",
+				"response_types_supported":              []string{"code"},
+				"subject_types_supported":               []string{"public"},
+				"id_token_signing_alg_values_supported": []string{"RS256"},
+			}); err != nil {
+				t.Error(err)
+			}
+		case "/token":
+			if err := r.ParseForm(); err != nil {
+				t.Error(err)
+			}
+			assertion, err := base64.RawURLEncoding.DecodeString(r.Form.Get("assertion"))
+			if r.Form.Get("grant_type") == GrantTypeSAML2Bearer && err == nil && string(assertion) == testAssertion {
+				w.Header().Set("Content-Type", "application/json")
+				resp := mockTokenResponse{
+					AccessToken: "saml_access_token",
+					ExpiresIn:   3600,
+					TokenType:   "Bearer",
+				}
+				if err := json.NewEncoder(w).Encode(resp); err != nil {
+					t.Error(err)
+				}
+			} else {
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	testServer = httptest.NewServer(handler)
+	defer testServer.Close()
+
+	assertionFile := filepath.Join(t.TempDir(), "assertion.xml")
+	if err := os.WriteFile(assertionFile, []byte(testAssertion+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write assertion file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		saml *config.SAMLConfig
+	}{
+		{name: "File", saml: &config.SAMLConfig{AssertionFile: assertionFile}},
+		{name: "Command", saml: &config.SAMLConfig{AssertionCommand: []string{"cat", assertionFile}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				OIDC: config.OIDCConfig{
+					IssuerURL:    testServer.URL,
+					ClientID:     "client",
+					ClientSecret: "secret",
+					AuthMethod:   "client_secret_basic",
+				},
+				// User credentials must not take precedence over SAML
+				User: config.UserConfig{Username: "user", Password: "pass"},
+				SAML: tt.saml,
+			}
+
+			client, err := NewClient(cfg)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+
+			token, err := client.GetToken(context.Background(), "", "")
+			if err != nil {
+				t.Fatalf("GetToken() error = %v", err)
+			}
+			if token.AccessToken != "saml_access_token" {
+				t.Errorf("expected access token 'saml_access_token', got %s", token.AccessToken)
+			}
+		})
+	}
+}
+
+func TestNewAssertionSource(t *testing.T) {
+	if _, err := newAssertionSource(&config.SAMLConfig{}); err == nil {
+		t.Error("newAssertionSource() expected error without a source, got nil")
+	}
+	if _, err := newAssertionSource(&config.SAMLConfig{AssertionFile: "a.xml", AssertionStdin: true}); err == nil {
+		t.Error("newAssertionSource() expected error with two sources, got nil")
+	}
+}
+
+func TestEncodeAssertion(t *testing.T) {
+	want := base64.RawURLEncoding.EncodeToString([]byte(testAssertion))
+
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{name: "XML", raw: "  " + testAssertion + "\n"},
+		{name: "Base64url", raw: want},
+		{name: "Padded standard base64", raw: base64.StdEncoding.EncodeToString([]byte(testAssertion))},
+		{name: "Wrapped standard base64", raw: wrap(base64.StdEncoding.EncodeToString([]byte(testAssertion)), 20)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encodeAssertion([]byte(tt.raw))
+			if err != nil {
+				t.Fatalf("encodeAssertion() error = %v", err)
+			}
+			if got != want {
+				t.Errorf("encodeAssertion() = %s, want %s", got, want)
+			}
+		})
+	}
+
+	if _, err := encodeAssertion([]byte("  \n")); err == nil {
+		t.Error("encodeAssertion() expected error for empty assertion, got nil")
+	}
+}
+
+// wrap breaks s into lines of at most n characters, as PEM-style tools do.
+func wrap(s string, n int) string {
+	var b strings.Builder
+	for len(s) > n {
+		b.WriteString(s[:n] + "\r\n")
+		s = s[n:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+func TestAssertionSource_Stdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+	os.Stdin = r
+	if _, err := w.WriteString(testAssertion); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	source, err := newAssertionSource(&config.SAMLConfig{AssertionStdin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := source.Assertion(context.Background()); err != nil {
+		t.Fatalf("Assertion() error = %v", err)
+	}
+	// A second grant must not resend the expired assertion
+	_, err = source.Assertion(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Fatalf("Assertion() error = %v, want already used", err)
+	}
+	// Retrying cannot bring the assertion back
+	if class := retry.Classify(fmt.Errorf("failed to get SAML assertion: %w", err)); class.Retryable {
+		t.Errorf("Classify() = %+v, want not retryable", class)
+	}
+}
@@ -0,0 +1,152 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/jwtclaims"
+	"github.com/rs/zerolog/log"
+)
+
+// skewMeter tracks the offset between the IdP clock and the local clock,
+// positive when the IdP is ahead.
+type skewMeter struct {
+	warnAbove time.Duration
+
+	mu         sync.Mutex
+	skew       time.Duration
+	measuredAt time.Time
+}
+
+// defaultWarnSkewAbove matches the schema default.
+const defaultWarnSkewAbove = 30 * time.Second
+
+func newSkewMeter(cfg config.ClockConfig) (*skewMeter, error) {
+	if cfg.WarnSkewAbove == "" {
+		return &skewMeter{warnAbove: defaultWarnSkewAbove}, nil
+	}
+	warnAbove, err := time.ParseDuration(cfg.WarnSkewAbove)
+	if err != nil {
+		return nil, fmt.Errorf("invalid clock.warnSkewAbove: %w", err)
+	}
+	return &skewMeter{warnAbove: warnAbove}, nil
+}
+
+// observe records a measurement and warns when it exceeds the threshold.
+func (m *skewMeter) observe(skew time.Duration, source string) {
+	m.mu.Lock()
+	m.skew = skew
+	m.measuredAt = time.Now()
+	m.mu.Unlock()
+
+	if skew.Abs() > m.warnAbove {
+		log.Warn().
+			Dur("skew", skew).
+			Str("source", source).
+			Msg(fmt.Sprintf("Local clock is %s the IdP, tokens may be rejected as expired or not yet valid; synchronise the clock with NTP", DescribeSkew(skew)))
+	} else {
+		log.Debug().Dur("skew", skew).Str("source", source).Msg("Measured clock skew")
+	}
+}
+
+// Skew returns the last measured offset, or 0 before any measurement.
+func (m *skewMeter) Skew() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.skew
+}
+
+// measuredSince reports whether a measurement was taken after t.
+func (m *skewMeter) measuredSince(t time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.measuredAt.Before(t)
+}
+
+// DescribeSkew phrases skew, the IdP clock minus the local clock, from the
+// local clock's point of view, as in "Local clock is 3m0s ahead of the IdP".
+func DescribeSkew(skew time.Duration) string {
+	if skew > 0 {
+		return fmt.Sprintf("%s behind", skew.Round(time.Second))
+	}
+	return fmt.Sprintf("%s ahead of", (-skew).Round(time.Second))
+}
+
+// skewTransport measures the clock skew from the Date header of every
+// response from the IdP.
+type skewTransport struct {
+	base  http.RoundTripper
+	meter *skewMeter
+}
+
+func (t *skewTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sent := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if skew, ok := dateSkew(resp.Header.Get("Date"), sent, time.Now()); ok {
+		t.meter.observe(skew, "date_header")
+	}
+	return resp, nil
+}
+
+// dateSkew compares a Date header with the middle of the request. The header
+// has a one second resolution, so the server time is taken to be half a
+// second past it.
+func dateSkew(header string, sent, received time.Time) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return 0, false
+	}
+	local := sent.Add(received.Sub(sent) / 2)
+	return date.Add(500 * time.Millisecond).Sub(local), true
+}
+
+// iatSkew compares the iat claim of a JWT access token with the time it was
+// received. It is only used without a Date header, since some IdPs, such as
+// Entra ID, backdate iat.
+func iatSkew(accessToken string, received time.Time) (time.Duration, bool) {
+	issued := jwtclaims.Parse(accessToken).Time("iat")
+	if issued.IsZero() {
+		return 0, false
+	}
+	return issued.Sub(received), true
+}
+
+// MeasureClockSkew measures the offset between the IdP clock and the local
+// clock from the Date header of the discovery document, without
+// authenticating. It is positive when the IdP is ahead.
+func MeasureClockSkew(ctx context.Context, cfg *config.Config) (time.Duration, error) {
+	discoveryURL := strings.TrimSuffix(cfg.OIDC.IssuerURL, "/")
+	if cfg.OIDC.InsecureDiscovery != nil {
+		discoveryURL = strings.TrimSuffix(strings.TrimSuffix(cfg.OIDC.InsecureDiscovery.URL, "/.well-known/openid-configuration"), "/")
+	}
+	discoveryURL += "/.well-known/openid-configuration"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	sent := time.Now()
+	resp, err := (&http.Client{Timeout: 5 * time.Second}).Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reach IdP: %w", err)
+	}
+	defer resp.Body.Close()
+
+	skew, ok := dateSkew(resp.Header.Get("Date"), sent, time.Now())
+	if !ok {
+		return 0, fmt.Errorf("IdP sent no Date header")
+	}
+	return skew, nil
+}
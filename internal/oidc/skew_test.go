@@ -0,0 +1,108 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+)
+
+// skewServer is a stand-in IdP whose clock is offset from the local clock.
+// Without dateHeader, it omits the Date header and only iat tells the time.
+func skewServer(t *testing.T, offset time.Duration, dateHeader bool) *httptest.Server {
+	var testServer *httptest.Server
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idpNow := time.Now().Add(offset)
+		if dateHeader {
+			w.Header().Set("Date", idpNow.UTC().Format(http.TimeFormat))
+		} else {
+			w.Header()["Date"] = nil
+		}
+
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			if err := json.NewEncoder(w).Encode(map[string]interface{}{
+				"issuer":                                testServer.URL,
+				"token_endpoint":                        testServer.URL + "/token",
+				"jwks_uri":                              testServer.URL + "/certs",
+				"response_types_supported":              []string{"code"},
+				"subject_types_supported":               []string{"public"},
+				"id_token_signing_alg_values_supported": []string{"RS256"},
+			}); err != nil {
+				t.Error(err)
+			}
+		case "/token":
+			w.Header().Set("Content-Type", "application/json")
+			// No expires_in: the expiry comes from the exp claim
+			resp := mockTokenResponse{
+				AccessToken: unsignedJWT(fmt.Sprintf(`{"iat":%d,"exp":%d}`, idpNow.Unix(), idpNow.Add(time.Hour).Unix())),
+				TokenType:   "Bearer",
+			}
+			if err := json.NewEncoder(w).Encode(resp); err != nil {
+				t.Error(err)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	testServer = httptest.NewServer(handler)
+	t.Cleanup(testServer.Close)
+	return testServer
+}
+
+func TestClient_ClockSkew(t *testing.T) {
+	offset := -5 * time.Minute
+
+	for _, dateHeader := range []bool{true, false} {
+		t.Run(fmt.Sprintf("Date header %v", dateHeader), func(t *testing.T) {
+			testServer := skewServer(t, offset, dateHeader)
+			cfg := &config.Config{
+				OIDC: config.OIDCConfig{
+					IssuerURL:    testServer.URL,
+					ClientID:     "client",
+					ClientSecret: "secret",
+					AuthMethod:   "client_secret_basic",
+				},
+			}
+
+			client, err := NewClient(cfg)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			token, err := client.GetToken(context.Background(), "", "")
+			if err != nil {
+				t.Fatalf("GetToken() error = %v", err)
+			}
+
+			if skew := client.ClockSkew(); (skew - offset).Abs() > 2*time.Second {
+				t.Errorf("ClockSkew() = %s, want about %s", skew, offset)
+			}
+			// The exp claim is in IdP time, the expiry on the local clock
+			if remaining := time.Until(token.Expiry); (remaining - time.Hour).Abs() > 2*time.Second {
+				t.Errorf("token expires in %s, want about 1h", remaining)
+			}
+		})
+	}
+}
+
+func TestDateSkew(t *testing.T) {
+	sent := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	received := sent.Add(time.Second)
+
+	skew, ok := dateSkew("Wed, 01 Jan 2025 12:02:00 GMT", sent, received)
+	if !ok {
+		t.Fatal("dateSkew() failed to parse header")
+	}
+	if skew != 2*time.Minute {
+		t.Errorf("dateSkew() = %s, want 2m", skew)
+	}
+
+	if _, ok := dateSkew("", sent, received); ok {
+		t.Error("dateSkew() expected no measurement without header")
+	}
+}
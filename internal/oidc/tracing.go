@@ -0,0 +1,44 @@
+package oidc
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/codozor/authk/internal/oidc")
+
+// Span attributes. Tokens, secrets and credentials are never recorded.
+const (
+	attrIssuer    = attribute.Key("oidc.issuer")
+	attrProvider  = attribute.Key("oidc.provider")
+	attrGrantType = attribute.Key("oauth2.grant_type")
+)
+
+// tracingTransport records a client span for every request to the IdP and
+// propagates the trace context, so that IdP spans join authk's traces.
+func tracingTransport(base http.RoundTripper) http.RoundTripper {
+	return &detachTransport{
+		base: otelhttp.NewTransport(base, otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		})),
+	}
+}
+
+// detachTransport starts a new trace for requests made under a span that
+// already ended. go-oidc fetches keys with the context of discovery, and
+// key refreshes hours later do not belong to that trace.
+type detachTransport struct {
+	base http.RoundTripper
+}
+
+func (t *detachTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A sampled span stops recording once it ends
+	if span := trace.SpanFromContext(req.Context()); span.SpanContext().IsSampled() && !span.IsRecording() {
+		req = req.WithContext(trace.ContextWithSpanContext(req.Context(), trace.SpanContext{}))
+	}
+	return t.base.RoundTrip(req)
+}
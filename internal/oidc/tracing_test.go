@@ -0,0 +1,66 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingTransport(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	// Package tracers only follow the first global provider, so it is not
+	// restored
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var traceparents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparents = append(traceparents, r.Header.Get("Traceparent"))
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: tracingTransport(http.DefaultTransport)}
+
+	get := func(ctx context.Context) {
+		t.Helper()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/keys", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "oidc.discovery")
+	get(ctx)
+	parent.End()
+	// Later requests with the same context, like go-oidc key refreshes
+	get(ctx)
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	during, after := spans[0], spans[2]
+	if during.Name() != "GET /keys" {
+		t.Errorf("span name = %q, want %q", during.Name(), "GET /keys")
+	}
+	if during.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("expected the request made under a live span to be its child")
+	}
+	if after.Parent().IsValid() || after.SpanContext().TraceID() == parent.SpanContext().TraceID() {
+		t.Error("expected the request made after the span ended to start a new trace")
+	}
+	if len(traceparents) != 2 || traceparents[0] == "" {
+		t.Errorf("expected the trace context to be propagated, got %q", traceparents)
+	}
+}
@@ -0,0 +1,134 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// verifyPolicy holds the ID token checks configured under oidc.verify, on
+// top of the signature, issuer and expiry checks done by go-oidc.
+type verifyPolicy struct {
+	config         oidc.Config
+	audiences      []string
+	requiredClaims map[string]interface{}
+	// clockSkew, when set, replaces the go-oidc expiry check
+	clockSkew time.Duration
+	// issuerTemplate, when set, replaces the go-oidc issuer check
+	issuerTemplate string
+}
+
+func newVerifyPolicy(cfg *config.Config, prof profile) (*verifyPolicy, error) {
+	verify := cfg.OIDC.Verify
+
+	policy := &verifyPolicy{
+		config: oidc.Config{
+			ClientID:             cfg.OIDC.ClientID,
+			SupportedSigningAlgs: verify.Algorithms,
+			SkipExpiryCheck:      verify.SkipExpiryCheck,
+		},
+		requiredClaims: verify.RequiredClaims,
+	}
+
+	// Tokens from a tenant alias are issued by the user's own tenant, which
+	// go-oidc would compare to the literal "{tenantid}" issuer.
+	if template, ok := prof.expectedIssuer(prof.issuerURL(cfg.OIDC.IssuerURL)); ok {
+		policy.config.SkipIssuerCheck = true
+		policy.issuerTemplate = template
+	}
+
+	// go-oidc only accepts a single audience, so extra audiences are checked
+	// by the policy itself.
+	if len(verify.Audiences) > 0 {
+		policy.config.ClientID = ""
+		policy.config.SkipClientIDCheck = true
+		policy.audiences = append([]string{cfg.OIDC.ClientID}, verify.Audiences...)
+	}
+
+	if verify.ClockSkew != "" {
+		skew, err := time.ParseDuration(verify.ClockSkew)
+		if err != nil {
+			return nil, fmt.Errorf("invalid verify.clockSkew: %w", err)
+		}
+		// Tolerate tokens that expired up to skew ago on the local clock.
+		// Shifting config.Now instead would tighten the nbf and iat checks.
+		if !verify.SkipExpiryCheck && skew > 0 {
+			policy.config.SkipExpiryCheck = true
+			policy.clockSkew = skew
+		}
+	}
+
+	return policy, nil
+}
+
+// verify checks rawIDToken against the provider keys and the policy.
+func (p *verifyPolicy) verify(ctx context.Context, provider *oidc.Provider, rawIDToken string) (*oidc.IDToken, error) {
+	idToken, err := provider.Verifier(&p.config).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.issuerTemplate != "" && !matchIssuer(p.issuerTemplate, idToken.Issuer) {
+		return nil, fmt.Errorf("oidc: id token issued by a different provider, expected %q got %q", p.issuerTemplate, idToken.Issuer)
+	}
+
+	if p.clockSkew > 0 && time.Now().After(idToken.Expiry.Add(p.clockSkew)) {
+		return nil, fmt.Errorf("oidc: token is expired (Token Expiry: %v)", idToken.Expiry)
+	}
+
+	if len(p.audiences) > 0 && !containsAny(idToken.Audience, p.audiences) {
+		return nil, fmt.Errorf("expected audience in %q, got %q", p.audiences, idToken.Audience)
+	}
+
+	if len(p.requiredClaims) > 0 {
+		var claims map[string]interface{}
+		if err := idToken.Claims(&claims); err != nil {
+			return nil, fmt.Errorf("failed to decode claims: %w", err)
+		}
+		for name, expected := range p.requiredClaims {
+			actual, ok := claims[name]
+			if !ok {
+				return nil, fmt.Errorf("required claim %q is missing", name)
+			}
+			if !claimMatches(actual, expected) {
+				return nil, fmt.Errorf("claim %q is %v, expected %v", name, actual, expected)
+			}
+		}
+	}
+
+	return idToken, nil
+}
+
+// claimMatches reports whether a claim value satisfies the expected value.
+// Array claims such as "groups" match when they contain a scalar expected
+// value.
+func claimMatches(actual, expected interface{}) bool {
+	if reflect.DeepEqual(actual, expected) {
+		return true
+	}
+	if values, ok := actual.([]interface{}); ok {
+		if _, isList := expected.([]interface{}); !isList {
+			for _, v := range values {
+				if reflect.DeepEqual(v, expected) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func containsAny(values, candidates []string) bool {
+	for _, v := range values {
+		for _, c := range candidates {
+			if v == c {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+)
+
+// idTokenServer is a mock provider that returns a signed ID token with the
+// claims set by the test alongside every access token.
+type idTokenServer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+}
+
+func newIDTokenServer(t *testing.T) *idTokenServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	s := &idTokenServer{key: key}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			if err := json.NewEncoder(w).Encode(map[string]interface{}{
+				"issuer":                                s.URL,
+				"token_endpoint":                        s.URL + "/token",
+				"jwks_uri":                              s.URL + "/certs",
+				"response_types_supported":              []string{"code"},
+				"subject_types_supported":               []string{"public"},
+				"id_token_signing_alg_values_supported": []string{"RS256"},
+			}); err != nil {
+				t.Error(err)
+			}
+		case "/certs":
+			if err := json.NewEncoder(w).Encode(map[string]interface{}{
+				"keys": []map[string]string{{
+					"kty": "RSA",
+					"alg": "RS256",
+					"use": "sig",
+					"kid": "test",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				}},
+			}); err != nil {
+				t.Error(err)
+			}
+		case "/token":
+			w.Header().Set("Content-Type", "application/json")
+			resp := mockTokenResponse{
+				AccessToken: "mock_access_token",
+				ExpiresIn:   3600,
+				TokenType:   "Bearer",
+				IDToken:     s.sign(t),
+			}
+			if err := json.NewEncoder(w).Encode(resp); err != nil {
+				t.Error(err)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return s
+}
+
+// sign returns an RS256 JWT of the current claims.
+func (s *idTokenServer) sign(t *testing.T) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	payload, err := json.Marshal(s.claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestClient_GetToken_VerifyPolicy(t *testing.T) {
+	server := newIDTokenServer(t)
+	defer server.Close()
+
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		verify  config.VerifyConfig
+		wantErr bool
+	}{
+		{
+			name:   "Default audience",
+			claims: map[string]interface{}{"aud": "client", "exp": now.Add(time.Hour).Unix()},
+		},
+		{
+			name:    "Foreign audience rejected by default",
+			claims:  map[string]interface{}{"aud": "account", "azp": "client", "exp": now.Add(time.Hour).Unix()},
+			wantErr: true,
+		},
+		{
+			name:   "Extra audience accepted",
+			claims: map[string]interface{}{"aud": "account", "azp": "client", "exp": now.Add(time.Hour).Unix()},
+			verify: config.VerifyConfig{Audiences: []string{"account"}},
+		},
+		{
+			name:    "Extra audiences still require a match",
+			claims:  map[string]interface{}{"aud": "other", "exp": now.Add(time.Hour).Unix()},
+			verify:  config.VerifyConfig{Audiences: []string{"account"}},
+			wantErr: true,
+		},
+		{
+			name:    "Expired rejected",
+			claims:  map[string]interface{}{"aud": "client", "exp": now.Add(-time.Minute).Unix()},
+			wantErr: true,
+		},
+		{
+			name:   "Expired within clock skew",
+			claims: map[string]interface{}{"aud": "client", "exp": now.Add(-time.Minute).Unix()},
+			verify: config.VerifyConfig{ClockSkew: "2m"},
+		},
+		{
+			name:    "Expired beyond clock skew",
+			claims:  map[string]interface{}{"aud": "client", "exp": now.Add(-5 * time.Minute).Unix()},
+			verify:  config.VerifyConfig{ClockSkew: "2m"},
+			wantErr: true,
+		},
+		{
+			name:   "Clock skew keeps nbf tolerance",
+			claims: map[string]interface{}{"aud": "client", "exp": now.Add(time.Hour).Unix(), "nbf": now.Add(time.Minute).Unix()},
+			verify: config.VerifyConfig{ClockSkew: "10m"},
+		},
+		{
+			name:   "Expired with expiry check skipped",
+			claims: map[string]interface{}{"aud": "client", "exp": now.Add(-time.Hour).Unix()},
+			verify: config.VerifyConfig{SkipExpiryCheck: true},
+		},
+		{
+			name:    "Algorithm not allowed",
+			claims:  map[string]interface{}{"aud": "client", "exp": now.Add(time.Hour).Unix()},
+			verify:  config.VerifyConfig{Algorithms: []string{"ES256"}},
+			wantErr: true,
+		},
+		{
+			name:   "Required claims",
+			claims: map[string]interface{}{"aud": "client", "exp": now.Add(time.Hour).Unix(), "tenant": "acme", "groups": []string{"dev", "ops"}},
+			verify: config.VerifyConfig{RequiredClaims: map[string]interface{}{"tenant": "acme", "groups": "ops"}},
+		},
+		{
+			name:    "Required claim mismatch",
+			claims:  map[string]interface{}{"aud": "client", "exp": now.Add(time.Hour).Unix(), "tenant": "other"},
+			verify:  config.VerifyConfig{RequiredClaims: map[string]interface{}{"tenant": "acme"}},
+			wantErr: true,
+		},
+		{
+			name:    "Required claim missing",
+			claims:  map[string]interface{}{"aud": "client", "exp": now.Add(time.Hour).Unix()},
+			verify:  config.VerifyConfig{RequiredClaims: map[string]interface{}{"tenant": "acme"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.claims = map[string]interface{}{
+				"iss": server.URL,
+				"sub": "user",
+				"iat": now.Unix(),
+			}
+			for k, v := range tt.claims {
+				server.claims[k] = v
+			}
+
+			cfg := &config.Config{
+				OIDC: config.OIDCConfig{
+					IssuerURL:    server.URL,
+					ClientID:     "client",
+					ClientSecret: "secret",
+					AuthMethod:   "client_secret_basic",
+					Verify:       tt.verify,
+				},
+			}
+
+			client, err := NewClient(cfg)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+
+			_, err = client.GetToken(context.Background(), "", "")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewVerifyPolicy_InvalidClockSkew(t *testing.T) {
+	cfg := &config.Config{OIDC: config.OIDCConfig{ClientID: "client", Verify: config.VerifyConfig{ClockSkew: "soon"}}}
+	if _, err := newVerifyPolicy(cfg, profiles[ProviderGeneric]); err == nil {
+		t.Error("newVerifyPolicy() expected error for invalid clock skew, got nil")
+	}
+}
+
+func TestNewVerifyPolicy_EntraIssuer(t *testing.T) {
+	tests := []struct {
+		issuer   string
+		template string
+	}{
+		{issuer: "https://login.microsoftonline.com/common/v2.0", template: "https://login.microsoftonline.com/{tenantid}/v2.0"},
+		{issuer: "https://login.microsoftonline.com/0000-1111/v2.0"},
+	}
+
+	for _, tt := range tests {
+		cfg := &config.Config{OIDC: config.OIDCConfig{IssuerURL: tt.issuer, ClientID: "authk"}}
+		policy, err := newVerifyPolicy(cfg, profiles[ProviderEntra])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only tenant aliases trade the go-oidc issuer check for the template
+		if policy.issuerTemplate != tt.template || policy.config.SkipIssuerCheck != (tt.template != "") {
+			t.Errorf("%s: issuerTemplate = %q, SkipIssuerCheck = %v", tt.issuer, policy.issuerTemplate, policy.config.SkipIssuerCheck)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"golang.org/x/oauth2"
+)
+
+// Policy computes exponential backoff delays with jitter.
+type Policy struct {
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	Multiplier float64
+	// Jitter is the fraction of the delay that is randomised, from 0 to 1
+	Jitter float64
+
+	// rand returns a number in [0, 1), math/rand/v2 unless set by tests
+	rand func() float64
+}
+
+// NewPolicy builds a Policy from the retry section of the config.
+func NewPolicy(cfg config.RetryConfig) (Policy, error) {
+	base, err := time.ParseDuration(cfg.BaseDelay)
+	if err != nil {
+		return Policy{}, fmt.Errorf("invalid retry.baseDelay: %w", err)
+	}
+	maxDelay, err := time.ParseDuration(cfg.MaxDelay)
+	if err != nil {
+		return Policy{}, fmt.Errorf("invalid retry.maxDelay: %w", err)
+	}
+	if maxDelay < base {
+		return Policy{}, fmt.Errorf("retry.maxDelay %s is shorter than retry.baseDelay %s", maxDelay, base)
+	}
+
+	return Policy{
+		BaseDelay:  base,
+		MaxDelay:   maxDelay,
+		Multiplier: cfg.Multiplier,
+		Jitter:     cfg.Jitter,
+	}, nil
+}
+
+// Delay returns how long to wait before the given retry attempt, starting at 1.
+func (p Policy) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	multiplier := math.Max(p.Multiplier, 1)
+	delay := float64(p.BaseDelay) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxDelay > 0 {
+		delay = math.Min(delay, float64(p.MaxDelay))
+	}
+
+	if p.Jitter > 0 {
+		random := rand.Float64
+		if p.rand != nil {
+			random = p.rand
+		}
+		// Spread the delay over [delay*(1-jitter), delay]
+		delay -= delay * p.Jitter * random()
+	}
+
+	return time.Duration(delay)
+}
+
+// permanentErrorCodes are OAuth2 error codes that retrying cannot fix.
+var permanentErrorCodes = map[string]bool{
+	"invalid_grant":          true,
+	"invalid_client":         true,
+	"unauthorized_client":    true,
+	"unsupported_grant_type": true,
+	"invalid_scope":          true,
+	"invalid_request":        true,
+	"access_denied":          true,
+}
+
+// PermanentError marks an error that retrying cannot fix, such as an input
+// that can only be provided once.
+type PermanentError struct {
+	Err error
+}
+
+// Permanent wraps err so that Classify reports it as not retryable.
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Classification tells whether a failed token request is worth retrying.
+type Classification struct {
+	// Retryable is false for errors that need a configuration change, such as
+	// wrong credentials. Retrying those can lock accounts.
+	Retryable bool
+	// RetryAfter is the delay requested by the server, if any
+	RetryAfter time.Duration
+	// Reason is a short description for logs
+	Reason string
+}
+
+// Classify inspects an error returned by a token request. Errors from the
+// token endpoint are classified by OAuth2 error code and HTTP status, and a
+// PermanentError is never retryable; all other errors, such as network
+// failures, are retryable.
+func Classify(err error) Classification {
+	var permanentErr *PermanentError
+	if errors.As(err, &permanentErr) {
+		return Classification{Retryable: false, Reason: "permanent error"}
+	}
+
+	var retrieveErr *oauth2.RetrieveError
+	if !errors.As(err, &retrieveErr) {
+		return Classification{Retryable: true, Reason: "request failed"}
+	}
+
+	if permanentErrorCodes[retrieveErr.ErrorCode] {
+		return Classification{Retryable: false, Reason: retrieveErr.ErrorCode}
+	}
+
+	if retrieveErr.Response == nil {
+		return Classification{Retryable: true, Reason: "token endpoint error"}
+	}
+
+	status := retrieveErr.Response.StatusCode
+	switch {
+	case status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable:
+		return Classification{
+			Retryable:  true,
+			RetryAfter: parseRetryAfter(retrieveErr.Response.Header.Get("Retry-After"), time.Now()),
+			Reason:     http.StatusText(status),
+		}
+	case status >= 500 || status == http.StatusRequestTimeout:
+		return Classification{Retryable: true, Reason: http.StatusText(status)}
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return Classification{Retryable: false, Reason: http.StatusText(status)}
+	default:
+		return Classification{Retryable: true, Reason: http.StatusText(status)}
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP
+// date. It returns 0 when the header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,123 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"golang.org/x/oauth2"
+)
+
+func TestPolicy_Delay(t *testing.T) {
+	p := Policy{
+		BaseDelay:  time.Second,
+		MaxDelay:   10 * time.Second,
+		Multiplier: 2,
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if got := p.Delay(i + 1); got != w {
+			t.Errorf("Delay(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+
+	p.Jitter = 0.5
+	p.rand = func() float64 { return 1 }
+	if got := p.Delay(2); got != time.Second {
+		t.Errorf("Delay(2) with full jitter = %s, want 1s", got)
+	}
+	p.rand = func() float64 { return 0 }
+	if got := p.Delay(2); got != 2*time.Second {
+		t.Errorf("Delay(2) with no jitter drawn = %s, want 2s", got)
+	}
+}
+
+func TestNewPolicy(t *testing.T) {
+	p, err := NewPolicy(config.RetryConfig{BaseDelay: "10s", MaxDelay: "5m", Multiplier: 2, Jitter: 0.2})
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	if p.BaseDelay != 10*time.Second || p.MaxDelay != 5*time.Minute {
+		t.Errorf("unexpected policy: %+v", p)
+	}
+
+	invalid := []config.RetryConfig{
+		{BaseDelay: "soon", MaxDelay: "5m"},
+		{BaseDelay: "10s", MaxDelay: "later"},
+		{BaseDelay: "10m", MaxDelay: "5m"},
+	}
+	for _, cfg := range invalid {
+		if _, err := NewPolicy(cfg); err == nil {
+			t.Errorf("NewPolicy(%+v) expected error, got nil", cfg)
+		}
+	}
+}
+
+func retrieveError(status int, code string, header http.Header) error {
+	if header == nil {
+		header = http.Header{}
+	}
+	err := &oauth2.RetrieveError{
+		Response:  &http.Response{StatusCode: status, Header: header},
+		ErrorCode: code,
+	}
+	// GetToken wraps token endpoint errors
+	return fmt.Errorf("failed to get token: %w", err)
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantRetryable bool
+		wantAfter     time.Duration
+	}{
+		{name: "Wrong password", err: retrieveError(http.StatusBadRequest, "invalid_grant", nil), wantRetryable: false},
+		{name: "Wrong client secret", err: retrieveError(http.StatusUnauthorized, "invalid_client", nil), wantRetryable: false},
+		{name: "Unauthorized without code", err: retrieveError(http.StatusUnauthorized, "", nil), wantRetryable: false},
+		{name: "Server error", err: retrieveError(http.StatusBadGateway, "", nil), wantRetryable: true},
+		{
+			name:          "Rate limited",
+			err:           retrieveError(http.StatusTooManyRequests, "", http.Header{"Retry-After": []string{"120"}}),
+			wantRetryable: true,
+			wantAfter:     2 * time.Minute,
+		},
+		{
+			name:          "Unavailable without Retry-After",
+			err:           retrieveError(http.StatusServiceUnavailable, "temporarily_unavailable", nil),
+			wantRetryable: true,
+		},
+		{name: "Network error", err: errors.New("dial tcp: connection refused"), wantRetryable: true},
+		{name: "Permanent", err: fmt.Errorf("failed: %w", Permanent(errors.New("input used"))), wantRetryable: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Classify(tt.err)
+			if got.Retryable != tt.wantRetryable {
+				t.Errorf("Classify().Retryable = %v, want %v", got.Retryable, tt.wantRetryable)
+			}
+			if got.RetryAfter != tt.wantAfter {
+				t.Errorf("Classify().RetryAfter = %s, want %s", got.RetryAfter, tt.wantAfter)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if got := parseRetryAfter("30", now); got != 30*time.Second {
+		t.Errorf("parseRetryAfter(30) = %s, want 30s", got)
+	}
+	if got := parseRetryAfter("Wed, 01 Jan 2025 12:01:00 GMT", now); got != time.Minute {
+		t.Errorf("parseRetryAfter(date) = %s, want 1m", got)
+	}
+	if got := parseRetryAfter("garbage", now); got != 0 {
+		t.Errorf("parseRetryAfter(garbage) = %s, want 0", got)
+	}
+}
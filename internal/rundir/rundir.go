@@ -0,0 +1,45 @@
+// Package rundir locates the per-user runtime directory holding the sockets
+// and lock files of running authk instances.
+package rundir
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Dir returns $XDG_RUNTIME_DIR/authk, or a per-user directory under the
+// temporary directory when XDG_RUNTIME_DIR is not set. Create it with
+// Ensure, which restricts it to the current user.
+func Dir() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "authk")
+	}
+	return filepath.Join(os.TempDir(), "authk-"+strconv.Itoa(os.Getuid()))
+}
+
+// Ensure creates the directory at path, accessible only by the current user.
+// An existing directory is only accepted if it is private to the current
+// user: the fallback under the temporary directory has a predictable name,
+// and another user creating it first could hold the locks or plant sockets
+// and state files.
+func Ensure(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := os.Mkdir(path, 0700); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	return checkPrivate(path, info)
+}
@@ -0,0 +1,63 @@
+package rundir
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestEnsure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authk")
+	if err := Ensure(path); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	// An existing private directory is reused
+	if err := Ensure(path); err != nil {
+		t.Fatalf("Ensure() on existing directory error = %v", err)
+	}
+}
+
+func TestEnsure_Refused(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix permissions do not apply on Windows")
+	}
+	tmpDir := t.TempDir()
+
+	shared := filepath.Join(tmpDir, "shared")
+	if err := os.Mkdir(shared, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(shared, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	link := filepath.Join(tmpDir, "link")
+	if err := os.Symlink(t.TempDir(), link); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(tmpDir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{name: "World-writable", path: shared, want: "expected 0700"},
+		{name: "Symlink", path: link, want: "not a directory"},
+		{name: "File", path: file, want: "not a directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Ensure(tt.path); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Ensure() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
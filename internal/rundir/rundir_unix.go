@@ -0,0 +1,21 @@
+//go:build !windows
+
+package rundir
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkPrivate refuses a directory that is not owned by the current user or
+// that group or others can access.
+func checkPrivate(path string, info os.FileInfo) error {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is owned by uid %d, not by the current user", path, stat.Uid)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return fmt.Errorf("%s has mode %o, expected 0700", path, perm)
+	}
+	return nil
+}
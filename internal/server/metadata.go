@@ -0,0 +1,134 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Metadata server compatibility routes.
+const (
+	CompatNone  = ""
+	CompatGCE   = "gce"
+	CompatAzure = "azure"
+)
+
+// TokenSource hands out the current token. It is implemented by
+// *daemon.Daemon.
+type TokenSource interface {
+	Token() *oauth2.Token
+}
+
+// tokenResponse is the body of /token.
+type tokenResponse struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresIn   int64     `json:"expires_in,omitempty"`
+	Expiry      time.Time `json:"expiry,omitzero"`
+}
+
+// gceTokenResponse mimics the GCE metadata server token route.
+type gceTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+	TokenType   string `json:"token_type"`
+}
+
+// azureTokenResponse mimics Azure IMDS, which reports numbers as strings.
+type azureTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   string `json:"expires_in"`
+	ExpiresOn   string `json:"expires_on"`
+	Resource    string `json:"resource"`
+	TokenType   string `json:"token_type"`
+}
+
+// NewMetadataHandler serves the current token like a cloud metadata server.
+// /token always answers with a JSON body; compat adds the GCE or Azure IMDS
+// token route. Like real metadata servers, every route requires a header
+// that browsers and most SSRF vectors cannot set, and rejects requests that
+// went through a proxy.
+func NewMetadataHandler(source TokenSource, compat string) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /token", requireHeader(source, "Metadata-Flavor", "authk", func(w http.ResponseWriter, r *http.Request, token *oauth2.Token) {
+		writeJSON(w, http.StatusOK, tokenResponse{
+			AccessToken: token.AccessToken,
+			TokenType:   tokenType(token),
+			ExpiresIn:   expiresIn(token),
+			Expiry:      token.Expiry,
+		})
+	}))
+
+	switch compat {
+	case CompatGCE:
+		mux.Handle("GET /computeMetadata/v1/instance/service-accounts/default/token", requireHeader(source, "Metadata-Flavor", "Google", func(w http.ResponseWriter, r *http.Request, token *oauth2.Token) {
+			w.Header().Set("Metadata-Flavor", "Google")
+			writeJSON(w, http.StatusOK, gceTokenResponse{
+				AccessToken: token.AccessToken,
+				ExpiresIn:   expiresIn(token),
+				TokenType:   tokenType(token),
+			})
+		}))
+	case CompatAzure:
+		mux.Handle("GET /metadata/identity/oauth2/token", requireHeader(source, "Metadata", "true", func(w http.ResponseWriter, r *http.Request, token *oauth2.Token) {
+			if r.URL.Query().Get("api-version") == "" {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "Required query variable 'api-version' is missing"})
+				return
+			}
+			var expiresOn string
+			if !token.Expiry.IsZero() {
+				expiresOn = strconv.FormatInt(token.Expiry.Unix(), 10)
+			}
+			writeJSON(w, http.StatusOK, azureTokenResponse{
+				AccessToken: token.AccessToken,
+				ExpiresIn:   strconv.FormatInt(expiresIn(token), 10),
+				ExpiresOn:   expiresOn,
+				Resource:    r.URL.Query().Get("resource"),
+				TokenType:   tokenType(token),
+			})
+		}))
+	}
+
+	return mux
+}
+
+// requireHeader rejects requests without the header or that went through a
+// proxy, and requests made before a valid token is available, then calls
+// next with the current token.
+func requireHeader(source TokenSource, name, value string, next func(http.ResponseWriter, *http.Request, *oauth2.Token)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(name) != value {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "missing required header " + name + ": " + value})
+			return
+		}
+		if r.Header.Get("X-Forwarded-For") != "" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "proxied requests are not allowed"})
+			return
+		}
+
+		token := source.Token()
+		if token == nil || (!token.Expiry.IsZero() && !token.Expiry.After(time.Now())) {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "no valid token available"})
+			return
+		}
+		next(w, r, token)
+	})
+}
+
+func tokenType(token *oauth2.Token) string {
+	if token.TokenType == "" {
+		return "Bearer"
+	}
+	return token.Type()
+}
+
+// expiresIn returns the remaining lifetime of token in seconds, 0 if unknown.
+func expiresIn(token *oauth2.Token) int64 {
+	if token.Expiry.IsZero() {
+		return 0
+	}
+	return int64(time.Until(token.Expiry).Seconds())
+}
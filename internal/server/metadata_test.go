@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+type staticToken struct {
+	token *oauth2.Token
+}
+
+func (s staticToken) Token() *oauth2.Token {
+	return s.token
+}
+
+func TestMetadataHandler(t *testing.T) {
+	valid := staticToken{&oauth2.Token{AccessToken: "access", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}}
+	expired := staticToken{&oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(-time.Minute)}}
+
+	const (
+		gceRoute   = "/computeMetadata/v1/instance/service-accounts/default/token"
+		azureRoute = "/metadata/identity/oauth2/token?api-version=2018-02-01&resource=api://backend"
+	)
+
+	tests := []struct {
+		name    string
+		source  staticToken
+		compat  string
+		path    string
+		headers map[string]string
+		code    int
+	}{
+		{name: "Token", source: valid, path: "/token", headers: map[string]string{"Metadata-Flavor": "authk"}, code: http.StatusOK},
+		{name: "Missing header", source: valid, path: "/token", code: http.StatusForbidden},
+		{name: "Proxied", source: valid, path: "/token", headers: map[string]string{"Metadata-Flavor": "authk", "X-Forwarded-For": "10.0.0.1"}, code: http.StatusForbidden},
+		{name: "No token yet", source: staticToken{}, path: "/token", headers: map[string]string{"Metadata-Flavor": "authk"}, code: http.StatusServiceUnavailable},
+		{name: "Expired", source: expired, path: "/token", headers: map[string]string{"Metadata-Flavor": "authk"}, code: http.StatusServiceUnavailable},
+		{name: "GCE", source: valid, compat: CompatGCE, path: gceRoute, headers: map[string]string{"Metadata-Flavor": "Google"}, code: http.StatusOK},
+		{name: "GCE not enabled", source: valid, path: gceRoute, headers: map[string]string{"Metadata-Flavor": "Google"}, code: http.StatusNotFound},
+		{name: "Azure", source: valid, compat: CompatAzure, path: azureRoute, headers: map[string]string{"Metadata": "true"}, code: http.StatusOK},
+		{name: "Azure without api-version", source: valid, compat: CompatAzure, path: "/metadata/identity/oauth2/token", headers: map[string]string{"Metadata": "true"}, code: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			NewMetadataHandler(tt.source, tt.compat).ServeHTTP(rec, req)
+
+			if rec.Code != tt.code {
+				t.Fatalf("GET %s = %d, want %d: %s", tt.path, rec.Code, tt.code, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var body map[string]interface{}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("invalid JSON body: %v", err)
+			}
+			if body["access_token"] != "access" {
+				t.Errorf("expected access_token 'access', got %v", body["access_token"])
+			}
+			if tt.compat == CompatAzure {
+				if _, ok := body["expires_on"].(string); !ok {
+					t.Errorf("expected expires_on as a string, got %v", body["expires_on"])
+				}
+				if body["resource"] != "api://backend" {
+					t.Errorf("expected resource to be echoed, got %v", body["resource"])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/codozor/authk/internal/daemon"
+	"github.com/codozor/authk/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// StatusSource reports the daemon state. It is implemented by *daemon.Daemon.
+type StatusSource interface {
+	Status() daemon.Status
+}
+
+// readiness is the body of /readyz.
+type readiness struct {
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason,omitempty"`
+	daemon.Status
+}
+
+// NewHandler serves /healthz, which succeeds while the process is alive, and
+// /readyz, which succeeds while the token is valid for at least minTTL and
+// the last refresh succeeded. Both describe the state of every target.
+// Prometheus metrics are served on /metrics.
+func NewHandler(source StatusSource, minTTL time.Duration) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, source.Status())
+	})
+
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		status := source.Status()
+		ready, reason := status.Ready(time.Now(), minTTL)
+
+		code := http.StatusOK
+		if !ready {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, readiness{Ready: ready, Reason: reason, Status: status})
+	})
+
+	mux.Handle("GET /metrics", metrics.Handler())
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Debug().Err(err).Msg("Failed to write response")
+	}
+}
+
+// Serve serves handler on ln until ctx is cancelled, then shuts down.
+func Serve(ctx context.Context, ln net.Listener, handler http.Handler) error {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("Failed to shut down HTTP server")
+		}
+	}()
+
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
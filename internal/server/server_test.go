@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/daemon"
+)
+
+type staticStatus daemon.Status
+
+func (s staticStatus) Status() daemon.Status {
+	return daemon.Status(s)
+}
+
+func TestHandler(t *testing.T) {
+	now := time.Now()
+	valid := staticStatus{
+		IssuedAt: now,
+		Expiry:   now.Add(time.Hour),
+		Targets:  []daemon.TargetStatus{{File: ".env", Key: "TOKEN", UpdatedAt: now}},
+	}
+	retrying := valid
+	retrying.LastError = "connection refused"
+	retrying.Attempt = 2
+
+	tests := []struct {
+		name   string
+		source staticStatus
+		path   string
+		code   int
+	}{
+		{name: "Alive", source: valid, path: "/healthz", code: http.StatusOK},
+		{name: "Alive while retrying", source: retrying, path: "/healthz", code: http.StatusOK},
+		{name: "Ready", source: valid, path: "/readyz", code: http.StatusOK},
+		{name: "Not ready while retrying", source: retrying, path: "/readyz", code: http.StatusServiceUnavailable},
+		{name: "Not ready before first token", source: staticStatus{}, path: "/readyz", code: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			NewHandler(tt.source, 30*time.Second).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.code {
+				t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.code)
+			}
+			var body struct {
+				Targets []daemon.TargetStatus `json:"targets"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("invalid JSON body: %v", err)
+			}
+			if len(body.Targets) != len(tt.source.Targets) {
+				t.Errorf("expected %d targets in body, got %d", len(tt.source.Targets), len(body.Targets))
+			}
+		})
+	}
+}
+
+func TestHandler_Metrics(t *testing.T) {
+	rec := httptest.NewRecorder()
+	NewHandler(staticStatus{}, 30*time.Second).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !strings.Contains(rec.Body.String(), "go_goroutines") {
+		t.Errorf("expected Prometheus metrics, got:\n%s", rec.Body.String())
+	}
+}
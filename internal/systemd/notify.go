@@ -0,0 +1,57 @@
+// Package systemd integrates authk with systemd: it reports readiness and
+// liveness through the sd_notify protocol and generates user units.
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variables set by systemd for services.
+const (
+	EnvNotifySocket = "NOTIFY_SOCKET"
+	EnvWatchdogUSec = "WATCHDOG_USEC"
+	EnvWatchdogPID  = "WATCHDOG_PID"
+)
+
+// Notify sends state assignments, such as "READY=1", to the service manager.
+// It reports false without error when authk does not run under systemd, or
+// under a unit that does not expect notifications.
+func Notify(state ...string) (bool, error) {
+	socket := os.Getenv(EnvNotifySocket)
+	if socket == "" {
+		return false, nil
+	}
+	// Abstract sockets are given with a leading @
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(strings.Join(state, "\n"))); err != nil {
+		return false, fmt.Errorf("failed to notify service manager: %w", err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns how often the service manager expects WATCHDOG=1,
+// or zero when the watchdog is disabled or meant for another process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv(EnvWatchdogUSec), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv(EnvWatchdogPID); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
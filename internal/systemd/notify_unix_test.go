@@ -0,0 +1,141 @@
+//go:build !windows
+
+package systemd
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/daemon"
+)
+
+// notifySocket listens on a NOTIFY_SOCKET for the duration of the test.
+func notifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv(EnvNotifySocket, path)
+	return conn
+}
+
+// receive returns the next notification.
+func receive(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 4096)
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no notification received: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv(EnvNotifySocket, "")
+	if sent, err := Notify("READY=1"); sent || err != nil {
+		t.Errorf("Notify() without socket = %v, %v, want false, nil", sent, err)
+	}
+
+	conn := notifySocket(t)
+	if sent, err := Notify("READY=1", "STATUS=ok"); !sent || err != nil {
+		t.Fatalf("Notify() = %v, %v, want true, nil", sent, err)
+	}
+	if got := receive(t, conn); got != "READY=1\nSTATUS=ok" {
+		t.Errorf("received %q", got)
+	}
+}
+
+type fakeSource struct {
+	status chan daemon.Status
+	last   daemon.Status
+}
+
+func (f *fakeSource) Status() daemon.Status {
+	select {
+	case f.last = <-f.status:
+	default:
+	}
+	return f.last
+}
+
+func TestWatch(t *testing.T) {
+	conn := notifySocket(t)
+	t.Setenv(EnvWatchdogUSec, "")
+
+	source := &fakeSource{status: make(chan daemon.Status)}
+	source.last.Targets = []daemon.TargetStatus{{File: ".env", Key: "TOKEN"}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Watch(ctx, source)
+		close(done)
+	}()
+
+	if got := receive(t, conn); got != "STATUS=Obtaining token" {
+		t.Errorf("first notification = %q", got)
+	}
+
+	// A token that could not be written is not ready yet
+	now := time.Now()
+	next := now.Add(time.Hour)
+	source.status <- daemon.Status{
+		IssuedAt:    now,
+		NextRefresh: next,
+		Targets:     []daemon.TargetStatus{{File: ".env", Key: "TOKEN", Error: "denied"}},
+	}
+	if got := receive(t, conn); strings.Contains(got, "READY=1") {
+		t.Errorf("notified readiness before a target was written: %q", got)
+	}
+
+	source.status <- daemon.Status{
+		IssuedAt:    now,
+		NextRefresh: next,
+		Targets:     []daemon.TargetStatus{{File: ".env", Key: "TOKEN", UpdatedAt: now}},
+	}
+	if got := receive(t, conn); got != "READY=1" {
+		t.Errorf("notification after target update = %q, want READY=1", got)
+	}
+
+	cancel()
+	if got := receive(t, conn); got != "STOPPING=1" {
+		t.Errorf("notification on stop = %q, want STOPPING=1", got)
+	}
+	<-done
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv(EnvWatchdogPID, "")
+	t.Setenv(EnvWatchdogUSec, "30000000")
+	if got := WatchdogInterval(); got != 30*time.Second {
+		t.Errorf("WatchdogInterval() = %v, want 30s", got)
+	}
+
+	t.Setenv(EnvWatchdogPID, "1")
+	if got := WatchdogInterval(); got != 0 {
+		t.Errorf("WatchdogInterval() for another process = %v, want 0", got)
+	}
+}
+
+func TestStalled(t *testing.T) {
+	now := time.Now()
+	if stalled(daemon.Status{}, now) {
+		t.Error("stalled() = true without a scheduled refresh")
+	}
+	if stalled(daemon.Status{NextRefresh: now.Add(-time.Minute)}, now) {
+		t.Error("stalled() = true for a refresh in progress")
+	}
+	if !stalled(daemon.Status{NextRefresh: now.Add(-time.Hour)}, now) {
+		t.Error("stalled() = false for a refresh an hour overdue")
+	}
+}
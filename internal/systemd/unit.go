@@ -0,0 +1,93 @@
+package systemd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Unit is a systemd --user service running the authk daemon.
+type Unit struct {
+	Description      string
+	WorkingDirectory string
+	// ExecStart is the command line, quoted when the unit is rendered
+	ExecStart []string
+	// Watchdog is the WatchdogSec= of the unit, none if zero
+	Watchdog time.Duration
+}
+
+// String renders the unit file.
+func (u Unit) String() string {
+	quoted := make([]string, len(u.ExecStart))
+	for i, arg := range u.ExecStart {
+		quoted[i] = quote(arg)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Unit]\n")
+	fmt.Fprintf(&b, "Description=%s\n", escapeSpecifiers(u.Description))
+	// No dependency on network-online.target: user managers cannot see it,
+	// and failed token requests are retried anyway
+	fmt.Fprintf(&b, "\n[Service]\n")
+	fmt.Fprintf(&b, "Type=notify\n")
+	fmt.Fprintf(&b, "WorkingDirectory=%s\n", escapeSpecifiers(u.WorkingDirectory))
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(quoted, " "))
+	fmt.Fprintf(&b, "Restart=on-failure\n")
+	fmt.Fprintf(&b, "RestartSec=30s\n")
+	if u.Watchdog > 0 {
+		fmt.Fprintf(&b, "WatchdogSec=%d\n", int(u.Watchdog.Seconds()))
+	}
+	fmt.Fprintf(&b, "\n[Install]\n")
+	fmt.Fprintf(&b, "WantedBy=default.target\n")
+	return b.String()
+}
+
+// UnitDir returns the directory systemd --user loads units from.
+func UnitDir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "systemd", "user"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "systemd", "user"), nil
+}
+
+// UnitName derives a unit name from the config path, so that several configs
+// can each have their own service.
+func UnitName(configPath string) string {
+	name := filepath.Base(filepath.Dir(configPath))
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	if name = strings.Trim(b.String(), "-."); name == "" {
+		return "authk.service"
+	}
+	return "authk-" + name + ".service"
+}
+
+// escapeSpecifiers protects the specifiers systemd expands in every value.
+func escapeSpecifiers(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+// quote escapes a single word of a command line, where systemd also expands
+// variables, quoting it when it contains whitespace or quotes.
+func quote(s string) string {
+	s = strings.ReplaceAll(escapeSpecifiers(s), "$", "$$")
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,63 @@
+package systemd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnit_String(t *testing.T) {
+	unit := Unit{
+		Description:      "authk for /home/me/My Project/authk.cue",
+		WorkingDirectory: "/home/me/$work/100%",
+		ExecStart:        []string{"/usr/local/bin/authk", "--config", "/home/me/My Project/authk.cue", "--listen", "127.0.0.1:8080"},
+		Watchdog:         time.Minute,
+	}
+	got := unit.String()
+
+	for _, line := range []string{
+		"Type=notify",
+		// Variables are only expanded in command lines
+		"WorkingDirectory=/home/me/$work/100%%",
+		`ExecStart=/usr/local/bin/authk --config "/home/me/My Project/authk.cue" --listen 127.0.0.1:8080`,
+		"Restart=on-failure",
+		"WatchdogSec=60",
+		"WantedBy=default.target",
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("unit does not contain %q:\n%s", line, got)
+		}
+	}
+	if strings.Contains(got, "network-online.target") {
+		t.Errorf("user unit depends on network-online.target:\n%s", got)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := map[string]string{
+		"/usr/bin/authk": "/usr/bin/authk",
+		"with space":     `"with space"`,
+		`say "hi"`:       `"say \"hi\""`,
+		"100%":           "100%%",
+		"$HOME":          "$$HOME",
+		"":               `""`,
+	}
+	for in, want := range tests {
+		if got := quote(in); got != want {
+			t.Errorf("quote(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestUnitName(t *testing.T) {
+	tests := map[string]string{
+		"/home/me/api/authk.cue":        "authk-api.service",
+		"/home/me/My Project/authk.cue": "authk-My-Project.service",
+		"/authk.cue":                    "authk.service",
+	}
+	for in, want := range tests {
+		if got := UnitName(in); got != want {
+			t.Errorf("UnitName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/codozor/authk/internal/daemon"
+	"github.com/rs/zerolog/log"
+)
+
+// pollInterval is how often the daemon state is checked for changes to
+// report, unless the watchdog asks for more frequent pings.
+const pollInterval = time.Second
+
+// stallThreshold is how long a refresh may be overdue before the daemon is
+// considered stuck and the watchdog is no longer pinged. It leaves room for
+// slow IdP requests and hooks.
+const stallThreshold = 5 * time.Minute
+
+// StatusSource reports the daemon state. It is implemented by *daemon.Daemon.
+type StatusSource interface {
+	Status() daemon.Status
+}
+
+// Watch reports the state of source to the service manager until ctx is
+// cancelled: READY=1 once the first token is written to its targets, STATUS=
+// whenever the next refresh changes, and WATCHDOG=1 pings when the unit has a
+// watchdog. It returns immediately when authk does not run under systemd.
+func Watch(ctx context.Context, source StatusSource) {
+	if os.Getenv(EnvNotifySocket) == "" {
+		return
+	}
+
+	interval := pollInterval
+	watchdog := WatchdogInterval()
+	if watchdog > 0 {
+		log.Debug().Dur("interval", watchdog).Msg("Systemd watchdog enabled")
+		interval = min(interval, watchdog/2)
+	}
+
+	ready := false
+	lastStatus := ""
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s := source.Status()
+
+		var state []string
+		if !ready && isReady(s) {
+			ready = true
+			state = append(state, "READY=1")
+		}
+		if status := describe(s); status != lastStatus {
+			lastStatus = status
+			state = append(state, "STATUS="+status)
+		}
+		if watchdog > 0 {
+			if stalled(s, time.Now()) {
+				log.Warn().Time("refresh_at", s.NextRefresh).Msg("Refresh is overdue, no longer pinging the systemd watchdog")
+			} else {
+				state = append(state, "WATCHDOG=1")
+			}
+		}
+		if len(state) > 0 {
+			if _, err := Notify(state...); err != nil {
+				log.Warn().Err(err).Msg("Failed to notify systemd")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if _, err := Notify("STOPPING=1"); err != nil {
+				log.Warn().Err(err).Msg("Failed to notify systemd")
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// isReady reports whether a token was obtained and written to a target, if
+// the daemon has any.
+func isReady(s daemon.Status) bool {
+	if !s.HasToken() {
+		return false
+	}
+	if len(s.Targets) == 0 {
+		return true
+	}
+	for _, target := range s.Targets {
+		if !target.UpdatedAt.IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// stalled reports whether the scheduled refresh should have happened long
+// ago, meaning the daemon loop is stuck.
+func stalled(s daemon.Status, now time.Time) bool {
+	return !s.NextRefresh.IsZero() && now.Sub(s.NextRefresh) > stallThreshold
+}
+
+// describe is the STATUS= line shown by systemctl status.
+func describe(s daemon.Status) string {
+	switch {
+	case s.LastError != "":
+		return fmt.Sprintf("Retry %d at %s after error: %s", s.Attempt, s.NextRefresh.Format(time.TimeOnly), s.LastError)
+	case !s.HasToken():
+		return "Obtaining token"
+	case s.NextRefresh.IsZero():
+		return "Token obtained"
+	default:
+		return "Next refresh at " + s.NextRefresh.Format(time.DateTime)
+	}
+}
@@ -0,0 +1,174 @@
+// Package tracing exports OpenTelemetry traces of IdP calls and target
+// writes. Instrumented packages create spans through the global tracer
+// provider, which records nothing until Setup installs an exporter.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/codozor/authk/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters.
+const (
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterNone   = "none"
+)
+
+// OTLP protocols.
+const (
+	ProtocolHTTP = "http/protobuf"
+	ProtocolGRPC = "grpc"
+)
+
+// AttrOutcome is "success" or "failure", set on every span by End.
+const AttrOutcome = attribute.Key("authk.outcome")
+
+// End records the outcome of the operation traced by span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(AttrOutcome.String("failure"))
+	} else {
+		span.SetAttributes(AttrOutcome.String("success"))
+	}
+	span.End()
+}
+
+// Setup installs the exporter selected by cfg, or by the standard OTEL_*
+// environment variables for settings cfg leaves out, as the global tracer
+// provider. Without either, tracing stays disabled. The returned function
+// flushes pending spans and must be called before exiting.
+func Setup(ctx context.Context, cfg *config.TracingConfig, version string) (func(context.Context) error, error) {
+	if cfg == nil {
+		cfg = &config.TracingConfig{}
+	}
+	noop := func(context.Context) error { return nil }
+
+	exporterName := exporterFromEnv(cfg)
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch exporterName {
+	case ExporterNone:
+		return noop, nil
+	case ExporterStdout:
+		// Stdout carries the printed token and the output of exec commands
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+	case ExporterOTLP:
+		exporter, err = otlpExporter(ctx, cfg)
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter %q, expected otlp, stdout or none", exporterName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	// Attributes from OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME win
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("authk"), semconv.ServiceVersion(version)),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe trace resource: %w", err)
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	}
+	// Otherwise the SDK honours OTEL_TRACES_SAMPLER
+	if cfg.SampleRatio != nil {
+		opts = append(opts, sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*cfg.SampleRatio))))
+	}
+	provider := sdktrace.NewTracerProvider(opts...)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// exporterFromEnv returns the exporter set in cfg, then in
+// OTEL_TRACES_EXPORTER. An OTLP endpoint set in the environment, or a
+// tracing section in the config, selects otlp.
+func exporterFromEnv(cfg *config.TracingConfig) string {
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" {
+		return ExporterNone
+	}
+	if cfg.Exporter != "" {
+		return cfg.Exporter
+	}
+	if name := os.Getenv("OTEL_TRACES_EXPORTER"); name != "" {
+		// The specification calls it console
+		if name == "console" {
+			return ExporterStdout
+		}
+		return name
+	}
+	if cfg.Endpoint != "" || os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		return ExporterOTLP
+	}
+	return ExporterNone
+}
+
+// otlpExporter creates an OTLP exporter. The exporters read the endpoint,
+// headers and TLS settings from the environment when cfg leaves them out.
+func otlpExporter(ctx context.Context, cfg *config.TracingConfig) (sdktrace.SpanExporter, error) {
+	protocol := cfg.Protocol
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	}
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	if protocol == "" {
+		protocol = ProtocolHTTP
+	}
+	withURL := strings.Contains(cfg.Endpoint, "://")
+
+	switch protocol {
+	case ProtocolHTTP:
+		var opts []otlptracehttp.Option
+		switch {
+		case withURL:
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		case cfg.Endpoint != "":
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	case ProtocolGRPC:
+		var opts []otlptracegrpc.Option
+		switch {
+		case withURL:
+			opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.Endpoint))
+		case cfg.Endpoint != "":
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q, expected http/protobuf or grpc", protocol)
+	}
+}
@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/codozor/authk/internal/config"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestExporterFromEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.TracingConfig
+		env  map[string]string
+		want string
+	}{
+		{name: "nothing set", want: ExporterNone},
+		{name: "config", cfg: config.TracingConfig{Exporter: ExporterStdout}, want: ExporterStdout},
+		{name: "config endpoint", cfg: config.TracingConfig{Endpoint: "localhost:4318"}, want: ExporterOTLP},
+		{name: "environment", env: map[string]string{"OTEL_TRACES_EXPORTER": "otlp"}, want: ExporterOTLP},
+		{name: "console", env: map[string]string{"OTEL_TRACES_EXPORTER": "console"}, want: ExporterStdout},
+		{name: "environment endpoint", env: map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318"}, want: ExporterOTLP},
+		{
+			name: "config wins",
+			cfg:  config.TracingConfig{Exporter: ExporterNone},
+			env:  map[string]string{"OTEL_TRACES_EXPORTER": "otlp"},
+			want: ExporterNone,
+		},
+		{
+			name: "sdk disabled",
+			cfg:  config.TracingConfig{Exporter: ExporterOTLP},
+			env:  map[string]string{"OTEL_SDK_DISABLED": "true"},
+			want: ExporterNone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"OTEL_SDK_DISABLED", "OTEL_TRACES_EXPORTER", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"} {
+				t.Setenv(name, tt.env[name])
+			}
+			if got := exporterFromEnv(&tt.cfg); got != tt.want {
+				t.Errorf("exporterFromEnv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetup_Invalid(t *testing.T) {
+	if _, err := Setup(context.Background(), &config.TracingConfig{Exporter: "zipkin"}, "dev"); err == nil {
+		t.Error("expected an error for an unsupported exporter")
+	}
+	if _, err := Setup(context.Background(), &config.TracingConfig{Exporter: ExporterOTLP, Protocol: "http/json"}, "dev"); err == nil {
+		t.Error("expected an error for an unsupported protocol")
+	}
+}
+
+func TestEnd(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+
+	_, span := tracer.Start(context.Background(), "ok")
+	End(span, nil)
+	_, span = tracer.Start(context.Background(), "failed")
+	End(span, errors.New("invalid_grant"))
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 ended spans, got %d", len(spans))
+	}
+	for i, want := range []struct {
+		outcome string
+		code    codes.Code
+	}{{"success", codes.Unset}, {"failure", codes.Error}} {
+		span := spans[i]
+		if span.Status().Code != want.code {
+			t.Errorf("%s: status = %v, want %v", span.Name(), span.Status().Code, want.code)
+		}
+		var outcome string
+		for _, attr := range span.Attributes() {
+			if attr.Key == AttrOutcome {
+				outcome = attr.Value.AsString()
+			}
+		}
+		if outcome != want.outcome {
+			t.Errorf("%s: outcome = %q, want %q", span.Name(), outcome, want.outcome)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/daemon"
+	"github.com/codozor/authk/internal/retry"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// maxAttempts is how many times an event is sent before it is dropped
+	maxAttempts = 5
+	// queueSize is how many events wait for delivery to a webhook before
+	// new ones are dropped
+	queueSize = 32
+	// pollInterval is how often Watch checks the daemon state
+	pollInterval = time.Second
+	// overdueAfter is how late a refresh may be before the token is
+	// reported as expiring, when no error was reported
+	overdueAfter = time.Minute
+)
+
+// StatusSource reports the daemon state. It is implemented by *daemon.Daemon.
+type StatusSource interface {
+	Status() daemon.Status
+}
+
+// Notifier sends events to webhooks from background workers, one per
+// webhook, so that a slow or unreachable receiver never blocks the daemon
+// or the other webhooks. A nil *Notifier sends nothing.
+type Notifier struct {
+	expiringWithin time.Duration
+	host           string
+	config         string
+	client         *http.Client
+	retry          retry.Policy
+	workers        []*worker
+
+	// closed is set by Close, after which events are dropped
+	mu     sync.Mutex
+	closed bool
+
+	// stop aborts deliveries in flight once Close times out
+	ctx  context.Context
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+type worker struct {
+	webhook *Webhook
+	queue   chan Event
+}
+
+// NewNotifier builds a notifier for the alerts section of cfg, loaded from
+// configPath. It returns nil when no webhook is configured. Workers start
+// right away and run until Close.
+func NewNotifier(cfg *config.Config, configPath string) (*Notifier, error) {
+	if len(cfg.Alerts.Webhooks) == 0 {
+		return nil, nil
+	}
+
+	expiringWithin := 5 * time.Minute
+	if cfg.Alerts.ExpiringWithin != "" {
+		var err error
+		expiringWithin, err = time.ParseDuration(cfg.Alerts.ExpiringWithin)
+		if err != nil {
+			return nil, fmt.Errorf("invalid alerts.expiringWithin: %w", err)
+		}
+	}
+
+	var webhooks []*Webhook
+	for _, hc := range cfg.Alerts.Webhooks {
+		w, err := New(hc)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+
+	host, _ := os.Hostname()
+	if abs, err := filepath.Abs(configPath); err == nil {
+		configPath = abs
+	}
+	n := &Notifier{
+		expiringWithin: expiringWithin,
+		host:           host,
+		config:         configPath,
+		client:         &http.Client{},
+		retry:          retry.Policy{BaseDelay: 2 * time.Second, MaxDelay: time.Minute, Multiplier: 2, Jitter: 0.2},
+	}
+	n.ctx, n.stop = context.WithCancel(context.Background())
+	for _, w := range webhooks {
+		wk := &worker{webhook: w, queue: make(chan Event, queueSize)}
+		n.workers = append(n.workers, wk)
+		n.wg.Add(1)
+		go n.run(wk)
+	}
+	return n, nil
+}
+
+// Send queues e for every webhook that wants it, without waiting for
+// delivery. The message, time, host and config are filled in.
+func (n *Notifier) Send(e Event) {
+	if n == nil {
+		return
+	}
+	e.Time = time.Now()
+	e.Host = n.host
+	e.Config = n.config
+	e.Message = fmt.Sprintf("authk on %s for %s: %s", e.Host, e.Config, describe(e))
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	for _, wk := range n.workers {
+		if !wk.webhook.Wants(e.Type) {
+			continue
+		}
+		select {
+		case wk.queue <- e:
+		default:
+			log.Warn().Str("webhook", wk.webhook.String()).Str("event", e.Type).Msg("Webhook queue full, dropping event")
+		}
+	}
+}
+
+// Close waits up to timeout for queued events to be delivered, then stops
+// the workers.
+func (n *Notifier) Close(timeout time.Duration) {
+	if n == nil {
+		return
+	}
+	n.mu.Lock()
+	n.closed = true
+	for _, wk := range n.workers {
+		close(wk.queue)
+	}
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warn().Msg("Giving up on webhook events not delivered yet")
+	}
+	n.stop()
+}
+
+// run delivers the events queued for a webhook, retrying with backoff.
+func (n *Notifier) run(wk *worker) {
+	defer n.wg.Done()
+	for e := range wk.queue {
+		for attempt := 1; ; attempt++ {
+			err := wk.webhook.send(n.ctx, n.client, e)
+			if err == nil {
+				log.Debug().Str("webhook", wk.webhook.String()).Str("event", e.Type).Msg("Webhook notified")
+				break
+			}
+
+			var deliveryErr *deliveryError
+			retryable := errors.As(err, &deliveryErr) && deliveryErr.retryable
+			if !retryable || attempt >= maxAttempts || n.ctx.Err() != nil {
+				log.Error().Err(err).Str("webhook", wk.webhook.String()).Str("event", e.Type).Int("attempt", attempt).Msg("Failed to notify webhook")
+				break
+			}
+
+			delay := n.retry.Delay(attempt)
+			log.Warn().Err(err).Str("webhook", wk.webhook.String()).Str("event", e.Type).Dur("retry_in", delay).Msg("Failed to notify webhook, retrying")
+			select {
+			case <-time.After(delay):
+			case <-n.ctx.Done():
+			}
+		}
+	}
+}
+
+// Watch sends events for changes in the state of source until ctx is
+// cancelled: failed when re-authentication starts failing, recovered once a
+// token is obtained again, and expiring when the token expires within
+// alerts.expiringWithin while it cannot be renewed.
+func (n *Notifier) Watch(ctx context.Context, source StatusSource) {
+	if n == nil {
+		return
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	w := watcher{prev: source.Status()}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, e := range w.observe(source.Status(), time.Now(), n.expiringWithin) {
+			n.Send(e)
+		}
+	}
+}
+
+// watcher turns changes of the daemon state into events.
+type watcher struct {
+	prev daemon.Status
+	// reported is the expiry of the last token reported as expiring
+	reported time.Time
+}
+
+// observe returns the events for a new state s at now.
+func (w *watcher) observe(s daemon.Status, now time.Time, expiringWithin time.Duration) []Event {
+	var events []Event
+	if w.prev.Attempt == 0 && s.Attempt > 0 {
+		events = append(events, Event{Type: EventFailed, Error: s.LastError, Attempt: s.Attempt})
+	}
+	if w.prev.Attempt > 0 && s.Attempt == 0 && s.HasToken() {
+		events = append(events, Event{Type: EventRecovered, Attempt: w.prev.Attempt})
+	}
+	if expiring(s, now, expiringWithin) && !s.Expiry.Equal(w.reported) {
+		w.reported = s.Expiry
+		events = append(events, Event{Type: EventExpiring, Error: s.LastError, Attempt: s.Attempt, Expiry: s.Expiry})
+	}
+	w.prev = s
+	return events
+}
+
+// expiring reports whether the token expires within expiringWithin at now
+// while renewing it fails or is overdue.
+func expiring(s daemon.Status, now time.Time, expiringWithin time.Duration) bool {
+	if s.Expiry.IsZero() || s.Expiry.Sub(now) > expiringWithin {
+		return false
+	}
+	overdue := !s.NextRefresh.IsZero() && now.Sub(s.NextRefresh) > overdueAfter
+	return s.LastError != "" || overdue
+}
+
+// describe is the human readable part of the message of e.
+func describe(e Event) string {
+	switch e.Type {
+	case EventFailed:
+		return fmt.Sprintf("re-authentication failed (attempt %d): %s", e.Attempt, e.Error)
+	case EventExpiring:
+		if remaining := e.Expiry.Sub(e.Time).Round(time.Second); remaining > 0 {
+			return fmt.Sprintf("token expires in %s without a successful refresh", remaining)
+		}
+		return fmt.Sprintf("token expired at %s without a successful refresh", e.Expiry.Format(time.RFC3339))
+	case EventRecovered:
+		return fmt.Sprintf("token renewed again after %d failed attempts", e.Attempt)
+	case EventStopped:
+		return "stopped: " + e.Error
+	default:
+		return e.Type
+	}
+}
@@ -0,0 +1,178 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+	"github.com/codozor/authk/internal/daemon"
+	"github.com/codozor/authk/internal/retry"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestNotifier_Send(t *testing.T) {
+	var requests atomic.Int32
+	received := make(chan Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail twice before accepting the event
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var e Event
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error(err)
+		}
+		received <- e
+	}))
+	defer srv.Close()
+
+	rejected := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(100)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer rejected.Close()
+
+	cfg := &config.Config{Alerts: config.AlertsConfig{Webhooks: []config.Webhook{
+		{URL: srv.URL},
+		{URL: rejected.URL, Events: []string{EventRecovered}},
+	}}}
+	n, err := NewNotifier(cfg, "/project/authk.cue")
+	if err != nil {
+		t.Fatalf("NewNotifier() error = %v", err)
+	}
+	n.retry = retry.Policy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	n.Send(Event{Type: EventFailed, Error: "connection refused", Attempt: 1})
+	select {
+	case e := <-received:
+		if e.Type != EventFailed || e.Config != "/project/authk.cue" || e.Message == "" {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not delivered")
+	}
+	n.Close(time.Second)
+
+	// The second webhook does not want failed events
+	if got := requests.Load(); got != 3 {
+		t.Errorf("expected 3 requests, got %d", got)
+	}
+
+	// Events sent after Close are dropped
+	n.Send(Event{Type: EventFailed})
+}
+
+func TestNotifier_Send_RedactsURL(t *testing.T) {
+	var logs bytes.Buffer
+	defer func(logger zerolog.Logger) { log.Logger = logger }(log.Logger)
+	log.Logger = zerolog.New(&logs)
+
+	// Nothing listens on the URL any more, so delivery fails
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	secret := "/services/T000/B000/XXXXSECRET"
+
+	cfg := &config.Config{Alerts: config.AlertsConfig{Webhooks: []config.Webhook{{URL: srv.URL + secret}}}}
+	n, err := NewNotifier(cfg, "authk.cue")
+	if err != nil {
+		t.Fatalf("NewNotifier() error = %v", err)
+	}
+	n.retry = retry.Policy{BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+
+	n.Send(Event{Type: EventFailed})
+	n.Close(5 * time.Second)
+
+	if !strings.Contains(logs.String(), "Failed to notify webhook") {
+		t.Fatalf("expected a delivery failure to be logged, got:\n%s", logs.String())
+	}
+	if strings.Contains(logs.String(), "SECRET") {
+		t.Errorf("webhook URL path was logged:\n%s", logs.String())
+	}
+}
+
+func TestNewNotifier_None(t *testing.T) {
+	n, err := NewNotifier(&config.Config{}, "authk.cue")
+	if n != nil || err != nil {
+		t.Fatalf("NewNotifier() without webhooks = %v, %v, want nil, nil", n, err)
+	}
+	// A nil notifier is usable
+	n.Send(Event{Type: EventFailed})
+	n.Close(time.Second)
+}
+
+func TestWatcher_Observe(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	healthy := daemon.Status{IssuedAt: now.Add(-time.Hour), Expiry: now.Add(time.Hour), NextRefresh: now.Add(59 * time.Minute)}
+	failing := healthy
+	failing.LastError = "connection refused"
+	failing.Attempt = 1
+	expiring := failing
+	expiring.Expiry = now.Add(4 * time.Minute)
+	expiring.Attempt = 4
+	recovered := healthy
+	recovered.IssuedAt = now
+
+	w := watcher{prev: healthy}
+	steps := []struct {
+		status daemon.Status
+		events []string
+	}{
+		{healthy, nil},
+		{failing, []string{EventFailed}},
+		{failing, nil},
+		{expiring, []string{EventExpiring}},
+		// Each token is only reported once
+		{expiring, nil},
+		{recovered, []string{EventRecovered}},
+	}
+	for i, step := range steps {
+		events := w.observe(step.status, now, 5*time.Minute)
+		var types []string
+		for _, e := range events {
+			types = append(types, e.Type)
+		}
+		if len(types) != len(step.events) || (len(types) > 0 && types[0] != step.events[0]) {
+			t.Errorf("step %d: events = %v, want %v", i, types, step.events)
+		}
+	}
+}
+
+func TestExpiring(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	within := 5 * time.Minute
+
+	tests := []struct {
+		name   string
+		status daemon.Status
+		want   bool
+	}{
+		{name: "Refresh scheduled", status: daemon.Status{Expiry: now.Add(2 * time.Minute), NextRefresh: now.Add(time.Minute)}},
+		{name: "Failing, far from expiry", status: daemon.Status{Expiry: now.Add(time.Hour), LastError: "boom"}},
+		{name: "Failing", status: daemon.Status{Expiry: now.Add(2 * time.Minute), LastError: "boom"}, want: true},
+		{name: "Refresh overdue", status: daemon.Status{Expiry: now.Add(2 * time.Minute), NextRefresh: now.Add(-2 * time.Minute)}, want: true},
+		{name: "Unknown expiry", status: daemon.Status{LastError: "boom"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expiring(tt.status, now, within); got != tt.want {
+				t.Errorf("expiring() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	e := Event{Type: EventExpiring, Time: now, Expiry: now.Add(3 * time.Minute)}
+	if got := describe(e); got != "token expires in 3m0s without a successful refresh" {
+		t.Errorf("describe() = %q", got)
+	}
+}
@@ -0,0 +1,231 @@
+// Package webhook notifies URLs, such as Slack or Teams incoming webhooks,
+// of failures and recovery of the token lifecycle.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"text/template"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+)
+
+// Events sent to webhooks.
+const (
+	// EventFailed is sent when re-authentication starts failing
+	EventFailed = "failed"
+	// EventExpiring is sent when a token that could not be renewed is about
+	// to expire
+	EventExpiring = "expiring"
+	// EventRecovered is sent when a token is obtained again after failures
+	EventRecovered = "recovered"
+	// EventStopped is sent when authk stops on an error it cannot recover
+	// from
+	EventStopped = "stopped"
+)
+
+// Body formats.
+const (
+	FormatJSON  = "json"
+	FormatSlack = "slack"
+	FormatTeams = "teams"
+)
+
+var allEvents = []string{EventFailed, EventExpiring, EventRecovered, EventStopped}
+
+// Event is a notification. It is the body sent in the json format, and the
+// data of templates.
+type Event struct {
+	Type    string    `json:"event"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+	Host    string    `json:"host"`
+	Config  string    `json:"config"`
+	// Error is the last error, for failed and stopped events
+	Error string `json:"error,omitempty"`
+	// Attempt is the number of failed re-authentications in a row
+	Attempt int `json:"attempt,omitempty"`
+	// Expiry is when the current token expires, for expiring events
+	Expiry time.Time `json:"expiry,omitzero"`
+}
+
+// Webhook is a URL receiving events.
+type Webhook struct {
+	url      string
+	format   string
+	template *template.Template
+	events   []string
+	headers  map[string]string
+	timeout  time.Duration
+}
+
+// New validates a webhook from the config.
+func New(cfg config.Webhook) (*Webhook, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook url %q: expected an http or https URL", redact(cfg.URL))
+	}
+
+	w := &Webhook{
+		url:     cfg.URL,
+		format:  cfg.Format,
+		events:  cfg.Events,
+		headers: cfg.Headers,
+		timeout: 10 * time.Second,
+	}
+	switch w.format {
+	case "":
+		w.format = FormatJSON
+	case FormatJSON, FormatSlack, FormatTeams:
+	default:
+		return nil, fmt.Errorf("unsupported webhook format %q, expected json, slack or teams", cfg.Format)
+	}
+	for _, event := range w.events {
+		if !slices.Contains(allEvents, event) {
+			return nil, fmt.Errorf("unsupported webhook event %q", event)
+		}
+	}
+	if len(w.events) == 0 {
+		w.events = allEvents
+	}
+	if cfg.Template != "" {
+		w.template, err = template.New("webhook").Funcs(template.FuncMap{"json": toJSON}).Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for webhook %s: %w", redact(cfg.URL), err)
+		}
+	}
+	if cfg.Timeout != "" {
+		w.timeout, err = time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout for webhook %s: %w", redact(cfg.URL), err)
+		}
+	}
+	return w, nil
+}
+
+// String identifies the webhook in logs without the path, which often
+// carries a secret.
+func (w *Webhook) String() string {
+	return redact(w.url)
+}
+
+// Wants reports whether the webhook receives events of type event.
+func (w *Webhook) Wants(event string) bool {
+	return slices.Contains(w.events, event)
+}
+
+// body renders the request body for e.
+func (w *Webhook) body(e Event) ([]byte, error) {
+	if w.template != nil {
+		var buf bytes.Buffer
+		if err := w.template.Execute(&buf, e); err != nil {
+			return nil, fmt.Errorf("failed to render template: %w", err)
+		}
+		return buf.Bytes(), nil
+	}
+
+	switch w.format {
+	case FormatSlack:
+		return json.Marshal(map[string]string{"text": e.Message})
+	case FormatTeams:
+		// An Adaptive Card, as expected by Teams workflows
+		return json.Marshal(map[string]any{
+			"type": "message",
+			"attachments": []any{map[string]any{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content": map[string]any{
+					"type":    "AdaptiveCard",
+					"version": "1.4",
+					"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+					"body": []any{map[string]any{
+						"type": "TextBlock",
+						"text": e.Message,
+						"wrap": true,
+					}},
+				},
+			}},
+		})
+	default:
+		return json.Marshal(e)
+	}
+}
+
+// deliveryError is a failed delivery, retryable unless the receiver
+// rejected the request.
+type deliveryError struct {
+	err       error
+	retryable bool
+}
+
+func (e *deliveryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *deliveryError) Unwrap() error {
+	return e.err
+}
+
+// send delivers e once.
+func (w *Webhook) send(ctx context.Context, client *http.Client, e Event) error {
+	body, err := w.body(e)
+	if err != nil {
+		return &deliveryError{err: err}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return &deliveryError{err: w.redactError(err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "authk")
+	for name, value := range w.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return &deliveryError{err: w.redactError(err), retryable: true}
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 300 {
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+		return &deliveryError{err: fmt.Errorf("webhook returned %s", resp.Status), retryable: retryable}
+	}
+	return nil
+}
+
+// toJSON lets templates embed values as JSON, such as {{json .Message}}.
+func toJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// redactError removes the URL of the webhook, which is a secret for Slack
+// and Teams, from the errors of net/http, which include it.
+func (w *Webhook) redactError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return &url.Error{Op: urlErr.Op, URL: redact(w.url), Err: urlErr.Err}
+	}
+	return err
+}
+
+func redact(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "webhook"
+	}
+	return u.Scheme + "://" + u.Host
+}
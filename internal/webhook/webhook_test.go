@@ -0,0 +1,91 @@
+package webhook
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codozor/authk/internal/config"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Webhook
+		wantErr string
+	}{
+		{name: "Valid", cfg: config.Webhook{URL: "https://hooks.slack.com/services/T/B/secret", Format: "slack"}},
+		{name: "Not HTTP", cfg: config.Webhook{URL: "ftp://example.com"}, wantErr: "invalid webhook url"},
+		{name: "Format", cfg: config.Webhook{URL: "https://example.com", Format: "discord"}, wantErr: "unsupported webhook format"},
+		{name: "Event", cfg: config.Webhook{URL: "https://example.com", Events: []string{"refreshed"}}, wantErr: "unsupported webhook event"},
+		{name: "Template", cfg: config.Webhook{URL: "https://example.com/secret", Template: "{{.Message"}, wantErr: "invalid template for webhook https://example.com:"},
+		{name: "Timeout", cfg: config.Webhook{URL: "https://example.com", Timeout: "soon"}, wantErr: "invalid timeout"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("New() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("New() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhook_Wants(t *testing.T) {
+	all, _ := New(config.Webhook{URL: "https://example.com"})
+	if !all.Wants(EventStopped) {
+		t.Error("a webhook without events should want every event")
+	}
+	some, _ := New(config.Webhook{URL: "https://example.com", Events: []string{EventFailed}})
+	if some.Wants(EventRecovered) || !some.Wants(EventFailed) {
+		t.Error("a webhook should only want the events it lists")
+	}
+}
+
+func TestWebhook_Body(t *testing.T) {
+	e := Event{Type: EventFailed, Message: `authk: failed "badly"`, Time: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC), Attempt: 2}
+
+	tests := []struct {
+		name string
+		cfg  config.Webhook
+		want string
+	}{
+		{name: "JSON", cfg: config.Webhook{}, want: `"event":"failed"`},
+		{name: "Slack", cfg: config.Webhook{Format: "slack"}, want: `{"text":"authk: failed \"badly\""}`},
+		{name: "Teams", cfg: config.Webhook{Format: "teams"}, want: `"type":"AdaptiveCard"`},
+		{name: "Template", cfg: config.Webhook{Format: "slack", Template: `{"content": {{json .Message}}, "attempt": {{.Attempt}}}`}, want: `{"content": "authk: failed \"badly\"", "attempt": 2}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.URL = "https://example.com"
+			w, err := New(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, err := w.body(e)
+			if err != nil {
+				t.Fatalf("body() error = %v", err)
+			}
+			if !json.Valid(body) {
+				t.Errorf("body() is not valid JSON: %s", body)
+			}
+			if !strings.Contains(string(body), tt.want) {
+				t.Errorf("body() = %s, want it to contain %s", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhook_String(t *testing.T) {
+	w, _ := New(config.Webhook{URL: "https://hooks.slack.com/services/T/B/secret?token=x"})
+	if got := w.String(); got != "https://hooks.slack.com" {
+		t.Errorf("String() = %q, want the URL without path and query", got)
+	}
+}